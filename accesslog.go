@@ -0,0 +1,91 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	accessLogFormatCommon   = "common"
+	accessLogFormatCombined = "combined"
+	accessLogFormatJSON     = "json"
+)
+
+const commonLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// accessLogEntry is the JSON form of one access log line.
+type accessLogEntry struct {
+	Time       string  `json:"time"`
+	Middleware string  `json:"middleware"`
+	TraceID    string  `json:"traceId"`
+	SpanID     string  `json:"spanId,omitempty"`
+	ParentID   string  `json:"parentSpanId,omitempty"`
+	RemoteAddr string  `json:"remoteAddr"`
+	Method     string  `json:"method"`
+	Host       string  `json:"host"`
+	Path       string  `json:"path"`
+	Protocol   string  `json:"protocol"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMs float64 `json:"durationMs"`
+	Referer    string  `json:"referer,omitempty"`
+	UserAgent  string  `json:"userAgent,omitempty"`
+}
+
+// writeAccessLog emits one line for a finished request, once next.ServeHTTP returned.
+func (t *TraceIDHeader) writeAccessLog(out io.Writer, req *http.Request, rw *responseWriter, traceValue string, start time.Time, duration time.Duration) {
+	var line []byte
+	switch t.accessLogFormat {
+	case accessLogFormatJSON:
+		traceID, _ := TraceIDFromContext(req.Context())
+		entry := accessLogEntry{
+			Time:       start.Format(time.RFC3339Nano),
+			Middleware: t.name,
+			TraceID:    traceValue,
+			SpanID:     traceID.SpanID,
+			ParentID:   traceID.ParentSpanID,
+			RemoteAddr: req.RemoteAddr,
+			Method:     req.Method,
+			Host:       req.Host,
+			Path:       req.URL.RequestURI(),
+			Protocol:   req.Proto,
+			Status:     rw.status,
+			Bytes:      rw.bytes,
+			DurationMs: durationMs(duration),
+			Referer:    req.Referer(),
+			UserAgent:  req.UserAgent(),
+		}
+		var err error
+		if line, err = json.Marshal(entry); err != nil {
+			return
+		}
+		line = append(line, '\n')
+	default:
+		size := "-"
+		if rw.bytes > 0 {
+			size = strconv.FormatInt(rw.bytes, 10)
+		}
+		user := "-"
+		if username, _, ok := req.BasicAuth(); ok && username != "" {
+			user = username
+		}
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+		// Common Log Format, extended (like Traefik's own) with our trace ID and the duration
+		line = fmt.Appendf(nil, "%s - %s [%s] \"%s %s %s\" %d %s", host, user, start.Format(commonLogTimeFormat),
+			req.Method, req.URL.RequestURI(), req.Proto, rw.status, size)
+		if t.accessLogFormat == accessLogFormatCombined {
+			line = fmt.Appendf(line, " %q %q", req.Referer(), req.UserAgent())
+		}
+		line = fmt.Appendf(line, " %q %dms\n", traceValue, duration.Milliseconds())
+	}
+
+	_, _ = out.Write(line)
+}
@@ -0,0 +1,87 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	tests := []struct {
+		format string
+		assert func(t *testing.T, line string, traceValue string)
+	}{
+		{
+			format: "common",
+			assert: func(t *testing.T, line string, traceValue string) {
+				t.Helper()
+				pattern := `^192\.0\.2\.1 - - \[[^\]]+\] "GET /teapot\?x=1 HTTP/1\.1" 418 5 "` + traceValue + `" \d+ms$`
+				if !regexp.MustCompile(pattern).MatchString(line) {
+					t.Fatalf("line %q does not match %s", line, pattern)
+				}
+			},
+		},
+		{
+			format: "combined",
+			assert: func(t *testing.T, line string, traceValue string) {
+				t.Helper()
+				pattern := `^192\.0\.2\.1 - - \[[^\]]+\] "GET /teapot\?x=1 HTTP/1\.1" 418 5 "http://referer/" "test-agent" "` + traceValue + `" \d+ms$`
+				if !regexp.MustCompile(pattern).MatchString(line) {
+					t.Fatalf("line %q does not match %s", line, pattern)
+				}
+			},
+		},
+		{
+			format: "json",
+			assert: func(t *testing.T, line string, traceValue string) {
+				t.Helper()
+				var entry accessLogEntry
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatalf("line %q is not JSON: %+v", line, err)
+				}
+				if entry.TraceID != traceValue || entry.Status != http.StatusTeapot || entry.Bytes != 5 || entry.Path != "/teapot?x=1" {
+					t.Fatalf("unexpected access log entry %+v", entry)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			ctx := context.Background()
+			logFile := filepath.Join(t.TempDir(), "access.log")
+
+			var traceValue string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				traceValue = req.Header.Get(defaultHeaderName)
+				rw.WriteHeader(http.StatusTeapot)
+				_, _ = rw.Write([]byte("short"))
+			})
+			config := &Config{AccessLog: true, AccessLogFormat: tt.format, LogOutput: logFile}
+			handler, err := New(ctx, next, config, "trace-id-test")
+			if err != nil {
+				t.Fatalf("error creating new plugin instance: %+v", err)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/teapot?x=1", nil)
+			if err != nil {
+				t.Fatalf("error with request: %+v", err)
+			}
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.Header.Set("Referer", "http://referer/")
+			req.Header.Set("User-Agent", "test-agent")
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			data, err := os.ReadFile(logFile)
+			if err != nil {
+				t.Fatalf("error reading log output: %+v", err)
+			}
+			tt.assert(t, strings.TrimSuffix(string(data), "\n"), traceValue)
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/cdwiegand/traefik-add-trace-id-header-2/ulid"
+	"github.com/cdwiegand/traefik-add-trace-id-header-2/uuid"
+)
+
+// Where the trace ID of a request came from.
+const (
+	TraceIDSourceGenerated  = "generated"  // we made it up
+	TraceIDSourcePropagated = "propagated" // a trusted client sent it to us
+)
+
+// Format of the (prefix/suffix stripped) trace ID.
+const (
+	TraceIDFormatUUID    = "uuid"
+	TraceIDFormatULID    = "ulid"
+	TraceIDFormatKSUID   = "ksuid" // only ever propagated, we don't generate them
+	TraceIDFormatUnknown = ""      // propagated value we could not parse
+)
+
+// TraceID is what the plugin stores in the request context for handlers further down the chain.
+type TraceID struct {
+	Value  string    // exactly what was put in the request header, prefix and suffix included
+	Source string    // TraceIDSourceGenerated or TraceIDSourcePropagated
+	Format string    // TraceIDFormatUUID, TraceIDFormatULID, TraceIDFormatKSUID or TraceIDFormatUnknown
+	UUID   uuid.UUID // parsed value, only set when Format is TraceIDFormatUUID
+	ULID   ulid.ULID // parsed value, only set when Format is TraceIDFormatULID
+	KSUID  KSUID     // parsed value, only set when Format is TraceIDFormatKSUID
+
+	CorrelationID string // session-level correlation ID, only set when correlation is enabled
+	SpanID        string // this hop's 64-bit span ID in hex, set when span IDs are enabled or a feature needs one
+	ParentSpanID  string // the previous hop's span ID in hex, if a trusted client sent one
+	Sampled       bool   // head-based sampling decision, always false when no sampler is configured
+}
+
+// Hex returns the 128 bits of a UUID or ULID trace ID as 32 lowercase hex
+// characters, as used by W3C Trace Context, or "" for other formats.
+func (id TraceID) Hex() string {
+	switch id.Format {
+	case TraceIDFormatUUID:
+		return hex.EncodeToString(id.UUID.Bytes())
+	case TraceIDFormatULID:
+		return hex.EncodeToString(id.ULID.Bytes())
+	}
+	return ""
+}
+
+// Time returns the timestamp embedded in a UUIDv7, ULID (milliseconds) or
+// KSUID (seconds) trace ID, ok is false for formats that don't carry one.
+func (id TraceID) Time() (t time.Time, ok bool) {
+	switch id.Format {
+	case TraceIDFormatUUID:
+		if id.UUID.Version() != uuid.V7 {
+			return time.Time{}, false
+		}
+		// the 48 bit unix milliseconds, read as is rather than through
+		// nanoseconds, which overflow for times after 2262
+		var ms int64
+		for _, b := range id.UUID[:6] {
+			ms = ms<<8 | int64(b)
+		}
+		return time.UnixMilli(ms).UTC(), true
+	case TraceIDFormatULID:
+		return ulid.Time(id.ULID.Time()).UTC(), true
+	case TraceIDFormatKSUID:
+		return id.KSUID.Time(), true
+	}
+	return time.Time{}, false
+}
+
+type traceIDContextKey struct{}
+
+// TraceIDFromContext returns the trace ID this middleware assigned to the request, if any.
+func TraceIDFromContext(ctx context.Context) (TraceID, bool) {
+	traceID, ok := ctx.Value(traceIDContextKey{}).(TraceID)
+	return traceID, ok
+}
+
+func contextWithTraceID(ctx context.Context, traceID TraceID) context.Context {
+	return context.WithValue(ctx, traceIDContextKey{}, traceID)
+}
+
+// parseTraceValue fills in Format and the parsed form of a trace ID value,
+// after stripping our configured prefix and suffix.
+func (t *TraceIDHeader) parseTraceValue(value string, source string) TraceID {
+	traceID := TraceID{Value: value, Source: source}
+
+	raw := strings.TrimSuffix(strings.TrimPrefix(value, t.valuePrefix), t.valueSuffix)
+	if t.hierarchical {
+		raw = hierarchyRoot(raw)
+	}
+	switch len(raw) {
+	case ulid.EncodedSize:
+		if id, err := ulid.ParseStrict(strings.ToUpper(raw)); err == nil {
+			traceID.Format = TraceIDFormatULID
+			traceID.ULID = id
+		}
+	case ksuidEncodedSize:
+		if id, err := parseKSUID(raw); err == nil {
+			traceID.Format = TraceIDFormatKSUID
+			traceID.KSUID = id
+		}
+	default:
+		if id, err := uuid.FromString(raw); err == nil {
+			traceID.Format = TraceIDFormatUUID
+			traceID.UUID = id
+		}
+	}
+	return traceID
+}
@@ -0,0 +1,137 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTraceIDFromContext(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     *Config
+		remoteAddr string
+		incoming   string
+		wantSource string
+		wantFormat string
+		wantValue  string
+	}{
+		{
+			name:       "generated uuid v4",
+			config:     &Config{UuidGen: "4"},
+			wantSource: TraceIDSourceGenerated,
+			wantFormat: TraceIDFormatUUID,
+		},
+		{
+			name:       "generated uuid v7",
+			config:     &Config{UuidGen: "7"},
+			wantSource: TraceIDSourceGenerated,
+			wantFormat: TraceIDFormatUUID,
+		},
+		{
+			name:       "generated ulid",
+			config:     &Config{UuidGen: "L"},
+			wantSource: TraceIDSourceGenerated,
+			wantFormat: TraceIDFormatULID,
+		},
+		{
+			name:       "untrusted client is ignored",
+			config:     &Config{TrustNetworks: []string{"10.0.0.0/8"}},
+			remoteAddr: "192.0.2.1:1234",
+			incoming:   "0191c5a2-3f7b-7cc3-9f3e-3a1b2c3d4e5f",
+			wantSource: TraceIDSourceGenerated,
+			wantFormat: TraceIDFormatUUID,
+		},
+		{
+			name:       "trusted network propagates uuid",
+			config:     &Config{TrustNetworks: []string{"10.0.0.0/8"}},
+			remoteAddr: "10.1.2.3:1234",
+			incoming:   "0191c5a2-3f7b-7cc3-9f3e-3a1b2c3d4e5f",
+			wantSource: TraceIDSourcePropagated,
+			wantFormat: TraceIDFormatUUID,
+			wantValue:  "0191c5a2-3f7b-7cc3-9f3e-3a1b2c3d4e5f",
+		},
+		{
+			name:       "trusted ip propagates prefixed ulid",
+			config:     &Config{TrustNetworks: []string{"192.0.2.1"}, ValuePrefix: "myorg-"},
+			remoteAddr: "192.0.2.1:1234",
+			incoming:   "myorg-01J6ZQ3V8K4M2N7P9R5S1T3W6X",
+			wantSource: TraceIDSourcePropagated,
+			wantFormat: TraceIDFormatULID,
+			wantValue:  "myorg-01J6ZQ3V8K4M2N7P9R5S1T3W6X",
+		},
+		{
+			name:       "trust all propagates unparseable value",
+			config:     &Config{TrustAllIPs: true},
+			remoteAddr: "192.0.2.1:1234",
+			incoming:   "abc123",
+			wantSource: TraceIDSourcePropagated,
+			wantFormat: TraceIDFormatUnknown,
+			wantValue:  "abc123",
+		},
+		{
+			name:       "invalid characters are replaced",
+			config:     &Config{TrustAllIPs: true},
+			remoteAddr: "192.0.2.1:1234",
+			incoming:   "abc 123",
+			wantSource: TraceIDSourceGenerated,
+			wantFormat: TraceIDFormatUUID,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var got TraceID
+			var found bool
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got, found = TraceIDFromContext(req.Context())
+			})
+			handler, err := New(ctx, next, tt.config, "trace-id-test")
+			if err != nil {
+				t.Fatalf("error creating new plugin instance: %+v", err)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+			if err != nil {
+				t.Fatalf("error with request: %+v", err)
+			}
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			if tt.incoming != "" {
+				req.Header.Set(defaultHeaderName, tt.incoming)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if !found {
+				t.Fatal("no trace ID found in request context")
+			}
+			if got.Source != tt.wantSource {
+				t.Fatalf("wanted source %q, got %q", tt.wantSource, got.Source)
+			}
+			if got.Format != tt.wantFormat {
+				t.Fatalf("wanted format %q, got %q", tt.wantFormat, got.Format)
+			}
+			if tt.wantValue != "" && got.Value != tt.wantValue {
+				t.Fatalf("wanted value %q, got %q", tt.wantValue, got.Value)
+			}
+			switch got.Format {
+			case TraceIDFormatUUID:
+				if got.UUID.String() != got.Value[len(got.Value)-36:] {
+					t.Fatalf("parsed UUID %s does not match value %s", got.UUID, got.Value)
+				}
+			case TraceIDFormatULID:
+				if got.ULID.String() != got.Value[len(got.Value)-26:] {
+					t.Fatalf("parsed ULID %s does not match value %s", got.ULID, got.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestTraceIDFromContextMissing(t *testing.T) {
+	if _, found := TraceIDFromContext(context.Background()); found {
+		t.Fatal("did not expect a trace ID in an empty context")
+	}
+}
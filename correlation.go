@@ -0,0 +1,51 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCorrelationCookieName = "trace_correlation"
+	defaultCorrelationHeaderName = "X-Correlation-Id"
+)
+
+func parseSameSite(sameSite string) (http.SameSite, error) {
+	switch strings.ToLower(sameSite) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("only correlation cookie SameSite of lax, strict, or none is supported")
+}
+
+// resolveCorrelationID returns the session-level correlation ID from the
+// client's cookie, or a fresh one when there is none (isNew), which then has to
+// be handed to the client with correlationCookie. It's empty if no ID could be
+// generated.
+func (t *TraceIDHeader) resolveCorrelationID(req *http.Request, now time.Time) (value string, isNew bool) {
+	if cookie, err := req.Cookie(t.correlationCookieName); err == nil && isValidIncomingTraceId(cookie.Value) {
+		return cookie.Value, false
+	}
+	if fresh := t.newTraceID(now).Value; fresh != "" {
+		return strings.TrimPrefix(fresh, t.valuePrefix), true
+	}
+	return "", false
+}
+
+func (t *TraceIDHeader) correlationCookie(value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     t.correlationCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   t.correlationCookieMaxAge,
+		Secure:   t.correlationCookieSecure,
+		HttpOnly: t.correlationCookieHttpOnly,
+		SameSite: t.correlationCookieSameSite,
+	}
+}
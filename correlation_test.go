@@ -0,0 +1,76 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCorrelationCookie(t *testing.T) {
+	ctx := context.Background()
+
+	var forwarded, traceValue string
+	var fromContext TraceID
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwarded = req.Header.Get("X-Correlation-Id")
+		traceValue = req.Header.Get(defaultHeaderName)
+		fromContext, _ = TraceIDFromContext(req.Context())
+	})
+	config := &Config{Correlation: true, CorrelationSecure: true, CorrelationHttpOnly: true, CorrelationSameSite: "strict", CorrelationMaxAge: 3600}
+	handler, err := New(ctx, next, config, "trace-id-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+
+	// first request: no cookie yet, so one is generated and handed out
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected one correlation cookie, got %d", len(cookies))
+	}
+	cookie := cookies[0]
+	if cookie.Name != defaultCorrelationCookieName || cookie.Value != forwarded || !cookie.Secure || !cookie.HttpOnly ||
+		cookie.SameSite != http.SameSiteStrictMode || cookie.MaxAge != 3600 {
+		t.Fatalf("unexpected correlation cookie %+v", cookie)
+	}
+	mustHaveLength(t, forwarded, 36)
+	if forwarded == traceValue {
+		t.Fatal("correlation ID should differ from the per-request trace ID")
+	}
+	if fromContext.CorrelationID != forwarded {
+		t.Fatalf("wanted correlation ID %q in context, got %q", forwarded, fromContext.CorrelationID)
+	}
+
+	// second request: the cookie is reused and not set again
+	firstCorrelation, firstTrace := forwarded, traceValue
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+	req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if forwarded != firstCorrelation {
+		t.Fatalf("wanted correlation ID %q to be kept, got %q", firstCorrelation, forwarded)
+	}
+	if traceValue == firstTrace {
+		t.Fatal("each request should still get its own trace ID")
+	}
+	if len(recorder.Result().Cookies()) != 0 {
+		t.Fatal("an existing correlation cookie should not be set again")
+	}
+}
+
+func TestNewRejectsUnknownSameSite(t *testing.T) {
+	if _, err := New(context.Background(), http.NotFoundHandler(), &Config{CorrelationSameSite: "sometimes"}, "trace-id-test"); err == nil {
+		t.Fatal("expected an error for an unknown SameSite value")
+	}
+}
@@ -0,0 +1,44 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"net/http"
+	"strings"
+)
+
+// isPreflight reports whether req is a CORS preflight, which never reaches the
+// actual handler upstream and so has no use for a trace ID.
+func isPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// exposeTraceHeaders adds our trace headers to Access-Control-Expose-Headers,
+// keeping whatever upstream already exposes, so browser scripts can read them.
+func (t *TraceIDHeader) exposeTraceHeaders(hdr http.Header) {
+	var exposed []string
+	seen := map[string]bool{}
+	for _, line := range hdr.Values("Access-Control-Expose-Headers") {
+		for _, name := range strings.Split(line, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || seen[strings.ToLower(name)] {
+				continue
+			}
+			seen[strings.ToLower(name)] = true
+			exposed = append(exposed, name)
+		}
+	}
+
+	added := false
+	for _, name := range t.exposedHeaders {
+		if seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		exposed = append(exposed, name)
+		added = true
+	}
+	if added {
+		hdr.Set("Access-Control-Expose-Headers", strings.Join(exposed, ", "))
+	}
+}
@@ -0,0 +1,98 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExposeTraceHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *Config
+		upstream []string
+		want     []string
+	}{
+		{
+			name:   "adds response header",
+			config: &Config{AddToResponse: true, ExposeHeaders: true},
+			want:   []string{"X-Trace-Id"},
+		},
+		{
+			name:     "merges with upstream",
+			config:   &Config{AddToResponse: true, ExposeHeaders: true, ExtraExposeHeaders: []string{"X-Request-Id"}},
+			upstream: []string{"Content-Range, x-trace-id", "ETag"},
+			want:     []string{"Content-Range, x-trace-id, ETag, X-Request-Id"},
+		},
+		{
+			name:   "includes upstream trace header",
+			config: &Config{AddToResponse: true, ExposeHeaders: true, ResponseHeaderName: "Request-Id", ExposeUpstreamHeader: "X-Upstream-Trace-Id"},
+			want:   []string{"Request-Id, X-Upstream-Trace-Id"},
+		},
+		{
+			name:   "needs addToResponse",
+			config: &Config{AddToResponse: false, ExposeHeaders: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				for _, v := range tt.upstream {
+					rw.Header().Add("Access-Control-Expose-Headers", v)
+				}
+			})
+			handler, err := New(ctx, next, tt.config, "trace-id-test")
+			if err != nil {
+				t.Fatalf("error creating new plugin instance: %+v", err)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+			if err != nil {
+				t.Fatalf("error with request: %+v", err)
+			}
+			req.Header.Set("Origin", "http://example.com")
+			recorder := httptest.NewRecorder()
+
+			handler.ServeHTTP(recorder, req)
+			mustHaveValues(t, recorder.Result().Header.Values("Access-Control-Expose-Headers"), tt.want...)
+		})
+	}
+}
+
+func TestPreflightSkipsTraceId(t *testing.T) {
+	ctx := context.Background()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get(defaultHeaderName) != "" {
+			t.Fatal("preflight request should not get a trace ID")
+		}
+		if req.Header.Get("traceparent") != "" || req.Header.Get("b3") != "" {
+			t.Fatal("untrusted preflight request should not pass on tracing headers")
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	handler, err := New(ctx, next, &Config{AddToResponse: true, ExposeHeaders: true, StripUntrusted: true}, "trace-id-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodOptions, "http://localhost/", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+	req.Header.Set("Origin", "http://example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set(defaultHeaderName, "spoofed")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("b3", "4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1")
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, req)
+	resp := recorder.Result()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("wanted status 204, got %d", resp.StatusCode)
+	}
+	mustHaveValues(t, resp.Header.Values(defaultHeaderName))
+	mustHaveValues(t, resp.Header.Values("Access-Control-Expose-Headers"))
+}
@@ -0,0 +1,95 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cdwiegand/traefik-add-trace-id-header-2/uuid"
+)
+
+// decodedTraceID is what the decode endpoint tells about a trace ID.
+type decodedTraceID struct {
+	Input     string   `json:"input"`
+	ID        string   `json:"id"` // without prefix, suffix and hierarchy
+	Format    string   `json:"format"`
+	Version   int      `json:"version,omitempty"`
+	Variant   string   `json:"variant,omitempty"`
+	Timestamp string   `json:"timestamp,omitempty"`
+	Hex       string   `json:"hex,omitempty"`
+	Valid     bool     `json:"valid"`
+	Problems  []string `json:"problems,omitempty"`
+}
+
+var uuidVariantNames = map[byte]string{
+	uuid.VariantNCS:       "NCS",
+	uuid.VariantRFC9562:   "RFC9562",
+	uuid.VariantMicrosoft: "Microsoft",
+	uuid.VariantFuture:    "future",
+}
+
+// decodeTraceValue explains a trace ID value, and whether this middleware
+// would have generated (or, for a trusted client, accepted) it as is.
+func (t *TraceIDHeader) decodeTraceValue(value string) decodedTraceID {
+	traceID := t.parseTraceValue(value, TraceIDSourcePropagated)
+	raw := strings.TrimSuffix(strings.TrimPrefix(value, t.valuePrefix), t.valueSuffix)
+	if t.hierarchical {
+		raw = hierarchyRoot(raw)
+	}
+	decoded := decodedTraceID{Input: value, ID: raw, Format: traceID.Format, Hex: traceID.Hex()}
+	if decoded.Format == "" {
+		decoded.Format = TraceIDFormatUnknown
+	}
+
+	var problems []string
+	if !isValidIncomingTraceId(value) {
+		problems = append(problems, "not accepted from clients: must be 1 to 128 visible ASCII characters")
+	}
+	if !strings.HasPrefix(value, t.valuePrefix) {
+		problems = append(problems, "missing prefix "+t.valuePrefix)
+	}
+	if !strings.HasSuffix(value, t.valueSuffix) {
+		problems = append(problems, "missing suffix "+t.valueSuffix)
+	}
+
+	switch traceID.Format {
+	case TraceIDFormatUUID:
+		decoded.Version = int(traceID.UUID.Version())
+		decoded.Variant = uuidVariantNames[traceID.UUID.Variant()]
+	case TraceIDFormatULID, TraceIDFormatKSUID:
+	default:
+		problems = append(problems, "not a UUID, ULID or KSUID")
+	}
+	switch t.uuidGen {
+	case "L":
+		if traceID.Format == TraceIDFormatUUID || traceID.Format == TraceIDFormatKSUID {
+			problems = append(problems, "this middleware generates ULIDs")
+		}
+	default:
+		if traceID.Format == TraceIDFormatULID || traceID.Format == TraceIDFormatKSUID ||
+			(traceID.Format == TraceIDFormatUUID && strconv.Itoa(decoded.Version) != t.uuidGen) {
+			problems = append(problems, "this middleware generates UUIDv"+t.uuidGen)
+		}
+	}
+	if ts, ok := traceID.Time(); ok {
+		decoded.Timestamp = ts.Format(time.RFC3339Nano)
+	}
+
+	decoded.Valid = len(problems) == 0
+	decoded.Problems = problems
+	return decoded
+}
+
+// isDecodeRequest is a GET for decodePath/<trace id>.
+func (t *TraceIDHeader) isDecodeRequest(req *http.Request) bool {
+	return t.decodePath != "" && strings.HasPrefix(req.URL.Path, t.decodePath) &&
+		(req.Method == http.MethodGet || req.Method == http.MethodHead)
+}
+
+func (t *TraceIDHeader) serveDecode(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(rw).Encode(t.decodeTraceValue(strings.TrimPrefix(req.URL.Path, t.decodePath)))
+}
@@ -0,0 +1,93 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDecodeEndpoint(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+		id     string
+		want   decodedTraceID
+	}{
+		{
+			name:   "uuidv7",
+			config: &Config{UuidGen: "7"},
+			id:     "01890a5d-ac96-774b-bcce-b302099a8057",
+			want:   decodedTraceID{Format: TraceIDFormatUUID, Version: 7, Variant: "RFC9562", Timestamp: "2023-06-30T03:34:18.518Z", Valid: true},
+		},
+		{
+			name:   "uuidv7 while generating v4",
+			config: &Config{UuidGen: "4"},
+			id:     "01890a5d-ac96-774b-bcce-b302099a8057",
+			want:   decodedTraceID{Format: TraceIDFormatUUID, Version: 7, Variant: "RFC9562", Timestamp: "2023-06-30T03:34:18.518Z"},
+		},
+		{
+			name:   "uuidv4 has no timestamp",
+			config: &Config{UuidGen: "4"},
+			id:     "f47ac10b-58cc-4372-a567-0e02b2c3d479",
+			want:   decodedTraceID{Format: TraceIDFormatUUID, Version: 4, Variant: "RFC9562", Valid: true},
+		},
+		{
+			name:   "ulid with prefix",
+			config: &Config{UuidGen: "L", ValuePrefix: "myorg-"},
+			id:     "myorg-01ARZ3NDEKTSV4RRFFQ69G5FAV",
+			want:   decodedTraceID{Format: TraceIDFormatULID, Timestamp: "2016-07-30T23:54:10.259Z", Valid: true},
+		},
+		{
+			name:   "ulid missing prefix",
+			config: &Config{UuidGen: "L", ValuePrefix: "myorg-"},
+			id:     "01ARZ3NDEKTSV4RRFFQ69G5FAV",
+			want:   decodedTraceID{Format: TraceIDFormatULID, Timestamp: "2016-07-30T23:54:10.259Z"},
+		},
+		{
+			name:   "ksuid is never generated here",
+			config: &Config{UuidGen: "L"},
+			id:     "0ujtsYcgvSTl8PAuAdqWYSMnLOv",
+			want:   decodedTraceID{Format: TraceIDFormatKSUID, Timestamp: "2017-10-10T04:00:47Z"},
+		},
+		{
+			name:   "garbage",
+			config: &Config{},
+			id:     "not-an-id",
+			want:   decodedTraceID{Format: TraceIDFormatUnknown},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			tt.config.DecodePath = "/.well-known/trace-id"
+
+			handler, err := New(ctx, http.NotFoundHandler(), tt.config, "trace-id-test")
+			if err != nil {
+				t.Fatalf("error creating new plugin instance: %+v", err)
+			}
+			recorder := httptest.NewRecorder()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/.well-known/trace-id/"+tt.id, nil)
+			if err != nil {
+				t.Fatalf("error with request: %+v", err)
+			}
+
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected the decode endpoint to answer, got %d", recorder.Code)
+			}
+			var got decodedTraceID
+			if err := json.NewDecoder(recorder.Body).Decode(&got); err != nil {
+				t.Fatalf("invalid decode response: %v", err)
+			}
+			if got.Input != tt.id || got.Format != tt.want.Format || got.Version != tt.want.Version || got.Variant != tt.want.Variant ||
+				got.Timestamp != tt.want.Timestamp || got.Valid != tt.want.Valid {
+				t.Fatalf("unexpected decode result %+v, wanted %+v", got, tt.want)
+			}
+			if !got.Valid && len(got.Problems) == 0 {
+				t.Fatal("an invalid trace ID should come with problems")
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"bytes"
+	"encoding/json"
+	"html"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var defaultErrorBodyContentTypes = []string{"application/json", "text/html", "text/plain"}
+
+// shouldRewriteErrorBody decides, once upstream's status and headers are known,
+// whether the body has to be buffered so we can add our trace ID to it.
+func (t *TraceIDHeader) shouldRewriteErrorBody(req *http.Request, hdr http.Header, code int) bool {
+	if len(t.errorBodyStatuses) == 0 || req.Method == http.MethodHead || !matchStatus(t.errorBodyStatuses, code) {
+		return false
+	}
+	if enc := hdr.Get("Content-Encoding"); enc != "" && !strings.EqualFold(enc, "identity") {
+		return false // compressed, we'd only mangle it
+	}
+	mediaType := errorBodyMediaType(hdr)
+	for _, ct := range t.errorBodyContentTypes {
+		if strings.EqualFold(ct, mediaType) {
+			return true
+		}
+		// application/problem+json and friends count as application/json
+		if strings.HasSuffix(mediaType, "+json") && strings.EqualFold(ct, "application/json") {
+			return true
+		}
+	}
+	return false
+}
+
+// errorBodyMediaType is the response media type, a bare error without one counts as text/plain.
+func errorBodyMediaType(hdr http.Header) string {
+	mediaType, _, err := mime.ParseMediaType(hdr.Get("Content-Type"))
+	if err != nil || mediaType == "" {
+		return "text/plain"
+	}
+	return strings.ToLower(mediaType)
+}
+
+// rewriteErrorBody adds the trace ID to an upstream error body: as a field of
+// a JSON object (wrapping anything else), or as a footer for HTML and text.
+func (t *TraceIDHeader) rewriteErrorBody(hdr http.Header, code int, body []byte, traceValue string) []byte {
+	mediaType := errorBodyMediaType(hdr)
+	if hdr.Get("Content-Type") == "" {
+		hdr.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+
+	switch {
+	case strings.HasSuffix(mediaType, "json"):
+		field, _ := json.Marshal(t.errorBodyField)
+		value, _ := json.Marshal(traceValue)
+		trimmed := bytes.TrimSpace(body)
+
+		var object map[string]json.RawMessage
+		if len(trimmed) > 0 && trimmed[0] == '{' && json.Unmarshal(trimmed, &object) == nil {
+			if _, exists := object[t.errorBodyField]; exists {
+				return body // upstream already told the client
+			}
+			// splice the field in up front, so upstream's key order survives
+			rest := bytes.TrimSpace(trimmed[1:])
+			out := append([]byte{'{'}, field...)
+			out = append(out, ':')
+			out = append(out, value...)
+			if len(rest) > 0 && rest[0] != '}' {
+				out = append(out, ',')
+			}
+			return append(out, rest...)
+		}
+
+		wrapper := map[string]any{t.errorBodyField: traceValue, "status": code}
+		switch {
+		case len(trimmed) == 0:
+			wrapper["error"] = http.StatusText(code)
+		case json.Valid(trimmed):
+			wrapper["error"] = json.RawMessage(trimmed)
+		default:
+			wrapper["error"] = string(trimmed)
+		}
+		out, _ := json.Marshal(wrapper)
+		return out
+	case mediaType == "text/html":
+		footer := `<p class="trace-id">Trace ID: <code>` + html.EscapeString(traceValue) + "</code></p>\n"
+		if len(bytes.TrimSpace(body)) == 0 {
+			footer = "<h1>" + strconv.Itoa(code) + " " + html.EscapeString(http.StatusText(code)) + "</h1>\n" + footer
+		}
+		if i := lastIndexFoldASCII(body, "</body>"); i >= 0 {
+			out := append([]byte{}, body[:i]...)
+			out = append(out, footer...)
+			return append(out, body[i:]...)
+		}
+		return append(body, footer...)
+	default:
+		var out []byte
+		if len(bytes.TrimSpace(body)) == 0 {
+			out = []byte(strconv.Itoa(code) + " " + http.StatusText(code) + "\n")
+		} else {
+			out = append(out, body...)
+			if !bytes.HasSuffix(out, []byte("\n")) {
+				out = append(out, '\n')
+			}
+		}
+		return append(out, "Trace ID: "+traceValue+"\n"...)
+	}
+}
+
+// lastIndexFoldASCII finds the last ASCII case-insensitive match of the
+// lower case needle in body. Unlike searching bytes.ToLower(body), the index
+// is valid for body itself, even when it isn't UTF-8 (e.g. a Latin-1 page).
+func lastIndexFoldASCII(body []byte, needle string) int {
+	for i := len(body) - len(needle); i >= 0; i-- {
+		match := true
+		for j := 0; j < len(needle); j++ {
+			c := body[i+j]
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			if c != needle[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
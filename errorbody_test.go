@@ -0,0 +1,165 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestErrorBodyRewrite(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		want        func(traceValue string) string
+	}{
+		{
+			name:        "json object gets a field",
+			status:      http.StatusBadGateway,
+			contentType: "application/json",
+			body:        `{"error":"bad gateway"}`,
+			want: func(traceValue string) string {
+				return `{"traceId":"` + traceValue + `","error":"bad gateway"}`
+			},
+		},
+		{
+			name:        "empty json object",
+			status:      http.StatusBadGateway,
+			contentType: "application/problem+json",
+			body:        `{}`,
+			want: func(traceValue string) string {
+				return `{"traceId":"` + traceValue + `"}`
+			},
+		},
+		{
+			name:        "json string is wrapped",
+			status:      http.StatusServiceUnavailable,
+			contentType: "application/json; charset=utf-8",
+			body:        `"down"`,
+			want: func(traceValue string) string {
+				return `{"error":"down","status":503,"traceId":"` + traceValue + `"}`
+			},
+		},
+		{
+			name:        "html gets a footer",
+			status:      http.StatusGatewayTimeout,
+			contentType: "text/html",
+			body:        "<html><body><h1>Timeout</h1></body></html>",
+			want: func(traceValue string) string {
+				return `<html><body><h1>Timeout</h1><p class="trace-id">Trace ID: <code>` + traceValue + "</code></p>\n</body></html>"
+			},
+		},
+		{
+			name:        "html that isn't UTF-8 keeps its markup",
+			status:      http.StatusBadGateway,
+			contentType: "text/html; charset=iso-8859-1",
+			body:        "<HTML><BODY><h1>Erreur \xe9\xe9\xe9</h1></BODY></HTML>",
+			want: func(traceValue string) string {
+				return "<HTML><BODY><h1>Erreur \xe9\xe9\xe9</h1>" + `<p class="trace-id">Trace ID: <code>` + traceValue + "</code></p>\n</BODY></HTML>"
+			},
+		},
+		{
+			name:   "bare error becomes plain text",
+			status: http.StatusBadGateway,
+			want: func(traceValue string) string {
+				return "502 Bad Gateway\nTrace ID: " + traceValue + "\n"
+			},
+		},
+		{
+			name:        "other statuses stream through",
+			status:      http.StatusInternalServerError,
+			contentType: "text/plain",
+			body:        "oops",
+			want: func(traceValue string) string {
+				return "oops"
+			},
+		},
+		{
+			name:        "other content types stream through",
+			status:      http.StatusBadGateway,
+			contentType: "image/png",
+			body:        "png",
+			want: func(traceValue string) string {
+				return "png"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var traceValue string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				traceValue = req.Header.Get(defaultHeaderName)
+				if tt.contentType != "" {
+					rw.Header().Set("Content-Type", tt.contentType)
+				}
+				rw.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+				rw.WriteHeader(tt.status)
+				_, _ = rw.Write([]byte(tt.body))
+			})
+			config := &Config{ErrorBodyStatuses: []string{"502", "503", "504"}}
+			handler, err := New(ctx, next, config, "trace-id-test")
+			if err != nil {
+				t.Fatalf("error creating new plugin instance: %+v", err)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+			if err != nil {
+				t.Fatalf("error with request: %+v", err)
+			}
+			recorder := httptest.NewRecorder()
+
+			handler.ServeHTTP(recorder, req)
+
+			resp := recorder.Result()
+			want := tt.want(traceValue)
+			if got := recorder.Body.String(); got != want {
+				t.Fatalf("wanted body %q, got %q", want, got)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("wanted status %d, got %d", tt.status, resp.StatusCode)
+			}
+			if resp.Header.Get("Content-Length") != strconv.Itoa(len(want)) {
+				t.Fatalf("wanted Content-Length %d, got %s", len(want), resp.Header.Get("Content-Length"))
+			}
+		})
+	}
+}
+
+func TestErrorBodyTooLargeIsPassedThrough(t *testing.T) {
+	ctx := context.Background()
+
+	chunk := strings.Repeat("x", 4<<10)
+	var want strings.Builder
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/html")
+		rw.WriteHeader(http.StatusBadGateway)
+		// streamed in chunks, so part of it is buffered before it outgrows the limit
+		for want.Len() <= maxBufferedBody {
+			_, _ = rw.Write([]byte(chunk))
+			want.WriteString(chunk)
+		}
+	})
+	config := &Config{ErrorBodyStatuses: []string{"502"}}
+	handler, err := New(ctx, next, config, "trace-id-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusBadGateway {
+		t.Fatalf("wanted status %d, got %d", http.StatusBadGateway, recorder.Code)
+	}
+	if recorder.Body.String() != want.String() {
+		t.Fatalf("wanted the %d byte body unchanged, got %d bytes", want.Len(), recorder.Body.Len())
+	}
+}
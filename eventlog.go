@@ -0,0 +1,186 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultEventLogMaxSizeMb  = 100
+	defaultEventLogMaxBackups = 3
+	defaultEventLogQueueSize  = 1024
+)
+
+// eventLogEntry is one line of the event log file.
+type eventLogEntry struct {
+	Time       string  `json:"time"`
+	Middleware string  `json:"middleware"`
+	TraceID    string  `json:"traceId"`
+	Source     string  `json:"source"`
+	SpanID     string  `json:"spanId,omitempty"`
+	Method     string  `json:"method"`
+	Host       string  `json:"host"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	LatencyMs  float64 `json:"latencyMs"`
+}
+
+// eventSink appends one JSON line per request to a file from its own
+// goroutine, so a slow disk never stalls ServeHTTP. The file is rotated to
+// path.1, path.2, ... once it grows past maxSize; entries that don't fit in the
+// queue are dropped and counted.
+type eventSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	queue      chan eventLogEntry
+	file       *os.File
+	size       int64
+	logger     *slog.Logger
+	dropped    int64
+	reported   int64
+}
+
+func newEventSink(ctx context.Context, path string, maxSizeMb, maxBackups, queueSize int, logger *slog.Logger) (*eventSink, error) {
+	if maxSizeMb <= 0 {
+		maxSizeMb = defaultEventLogMaxSizeMb
+	}
+	if maxBackups < 0 {
+		maxBackups = 0
+	}
+	if queueSize <= 0 {
+		queueSize = defaultEventLogQueueSize
+	}
+	s := &eventSink{
+		path:       path,
+		maxSize:    int64(maxSizeMb) << 20,
+		maxBackups: maxBackups,
+		queue:      make(chan eventLogEntry, queueSize),
+		logger:     logger,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	go s.run(ctx)
+	return s, nil
+}
+
+func (s *eventSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("can not open event log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("can not open event log file: %w", err)
+	}
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+// enqueue never blocks, a full queue means the entry is dropped.
+func (s *eventSink) enqueue(entry eventLogEntry) {
+	select {
+	case s.queue <- entry:
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+	}
+}
+
+func (s *eventSink) run(ctx context.Context) {
+	defer func() {
+		if s.file != nil {
+			_ = s.file.Close()
+		}
+	}()
+	for {
+		select {
+		case entry := <-s.queue:
+			s.write(entry)
+		case <-ctx.Done():
+			// middleware is going away, write what we have left
+			for {
+				select {
+				case entry := <-s.queue:
+					s.write(entry)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *eventSink) write(entry eventLogEntry) {
+	if dropped := atomic.LoadInt64(&s.dropped); dropped != s.reported {
+		s.logger.Warn("event log queue full, entries dropped", slog.Int64("entries", dropped-s.reported), slog.Int64("total", dropped))
+		s.reported = dropped
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			s.logger.Warn("event log rotation failed", slog.String("error", err.Error()))
+		}
+	}
+	if s.file == nil {
+		return
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		s.logger.Warn("event log write failed", slog.String("error", err.Error()))
+	}
+}
+
+// rotate shifts path.N-1 to path.N, ..., path to path.1 and starts a new file.
+func (s *eventSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		s.logger.Warn("event log close failed", slog.String("error", err.Error()))
+	}
+	s.file = nil
+	if s.maxBackups == 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return s.open()
+	}
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		from := s.path + "." + strconv.Itoa(i)
+		if err := os.Rename(from, s.path+"."+strconv.Itoa(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.open()
+}
+
+func (t *TraceIDHeader) newEventLogEntry(req *http.Request, rw *responseWriter, traceID TraceID, start, end time.Time) eventLogEntry {
+	return eventLogEntry{
+		Time:       start.UTC().Format(time.RFC3339Nano),
+		Middleware: t.name,
+		TraceID:    traceID.Value,
+		Source:     traceID.Source,
+		SpanID:     traceID.SpanID,
+		Method:     req.Method,
+		Host:       req.Host,
+		Path:       req.URL.Path,
+		Status:     rw.status,
+		LatencyMs:  durationMs(end.Sub(start)),
+	}
+}
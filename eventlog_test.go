@@ -0,0 +1,102 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readEventLog(t *testing.T, path string, want int) []eventLogEntry {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var entries []eventLogEntry
+		if f, err := os.Open(path); err == nil {
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				var entry eventLogEntry
+				if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+					t.Fatalf("invalid event log line %q: %v", scanner.Text(), err)
+				}
+				entries = append(entries, entry)
+			}
+			_ = f.Close()
+		}
+		if len(entries) >= want || time.Now().After(deadline) {
+			return entries
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEventLog(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+
+	var reqValue string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		reqValue = req.Header.Get("X-Trace-Id")
+		rw.WriteHeader(http.StatusNotFound)
+	})
+	handler, err := New(ctx, next, &Config{EventLogFile: path}, "trace-id-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://example.com/orders?id=1", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	entries := readEventLog(t, path, 1)
+	if len(entries) != 1 {
+		t.Fatalf("expected one event log line, got %d", len(entries))
+	}
+	entry := entries[0]
+	if entry.TraceID != reqValue || entry.Source != TraceIDSourceGenerated || entry.Middleware != "trace-id-test" {
+		t.Fatalf("unexpected trace fields %+v", entry)
+	}
+	if entry.Method != http.MethodPost || entry.Host != "example.com" || entry.Path != "/orders" || entry.Status != http.StatusNotFound {
+		t.Fatalf("unexpected request fields %+v", entry)
+	}
+}
+
+func TestEventLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	s := &eventSink{path: path, maxBackups: 2, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	if err := s.open(); err != nil {
+		t.Fatalf("error opening event log: %v", err)
+	}
+	t.Cleanup(func() { _ = s.file.Close() })
+	s.maxSize = 10 // every line rotates
+
+	for _, id := range []string{"a", "b", "c", "d"} {
+		s.write(eventLogEntry{TraceID: id})
+	}
+
+	for suffix, want := range map[string]string{"": "d", ".1": "c", ".2": "b"} {
+		entries := readEventLog(t, path+suffix, 1)
+		if len(entries) != 1 || entries[0].TraceID != want {
+			t.Fatalf("expected %s%s to hold %q, got %+v", path, suffix, want, entries)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("expected at most 2 backups, got %v", err)
+	}
+}
+
+func TestEventLogBadPath(t *testing.T) {
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{EventLogFile: filepath.Join(t.TempDir(), "missing", "events.jsonl")}, "trace-id-test")
+	if err == nil {
+		t.Fatal("expected an error for an event log file that can't be created")
+	}
+}
@@ -0,0 +1,218 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultExportBatchSize  = 100
+	defaultExportQueueSize  = 2048
+	defaultExportIntervalMs = 5000
+	defaultExportMaxRetries = 3
+
+	exportInitialBackoff = 100 * time.Millisecond
+	exportMaxBackoff     = 5 * time.Second
+)
+
+// exportStatusError is a collector answering with something other than 2xx.
+type exportStatusError struct {
+	status string
+	code   int
+}
+
+func (e *exportStatusError) Error() string {
+	return "collector answered " + e.status
+}
+
+// isRetryableExportError retries network errors, 429 and 5xx, but not other
+// 4xx, which won't get any better by sending the same batch again.
+func isRetryableExportError(err error) bool {
+	var statusErr *exportStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code == http.StatusTooManyRequests || statusErr.code >= 500
+	}
+	return true
+}
+
+// spanRecord is everything the exporters need to know about one proxied request.
+type spanRecord struct {
+	TraceHex     string // 32 hex characters, empty if the trace ID isn't a UUID/ULID
+	TraceValue   string
+	Source       string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Start        time.Time
+	End          time.Time
+	Method       string
+	Scheme       string
+	Host         string
+	Path         string
+	RemoteAddr   string
+	UserAgent    string
+	Status       int
+	Bytes        int64
+}
+
+func (t *TraceIDHeader) newSpanRecord(req *http.Request, rw *responseWriter, traceID TraceID, start, end time.Time) spanRecord {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return spanRecord{
+		TraceHex:     traceID.Hex(),
+		TraceValue:   traceID.Value,
+		Source:       traceID.Source,
+		SpanID:       traceID.SpanID,
+		ParentSpanID: traceID.ParentSpanID,
+		Name:         req.Method,
+		Start:        start,
+		End:          end,
+		Method:       req.Method,
+		Scheme:       scheme,
+		Host:         req.Host,
+		Path:         req.URL.Path,
+		RemoteAddr:   req.RemoteAddr,
+		UserAgent:    req.UserAgent(),
+		Status:       rw.status,
+		Bytes:        rw.bytes,
+	}
+}
+
+// shouldExport skips traces the sampler decided against; without a sampler everything is exported.
+func (t *TraceIDHeader) shouldExport(traceID TraceID) bool {
+	return t.sampler == "" || traceID.Sampled
+}
+
+// spanBatcher collects spans in a bounded queue and hands them to send in
+// batches from its own goroutine, so a slow collector never stalls ServeHTTP.
+// Failed batches are retried with exponential backoff; spans that don't fit in
+// the queue or run out of retries are dropped and counted.
+type spanBatcher struct {
+	kind       string
+	queue      chan spanRecord
+	send       func(ctx context.Context, batch []spanRecord) error
+	batchSize  int
+	interval   time.Duration
+	maxRetries int
+	logger     *slog.Logger
+	dropped    int64 // queue was full
+	failed     int64 // gave up sending
+	exported   int64
+	reported   int64 // dropped count we already logged
+}
+
+func newSpanBatcher(ctx context.Context, kind string, send func(ctx context.Context, batch []spanRecord) error, batchSize, queueSize int, interval time.Duration, maxRetries int, logger *slog.Logger) *spanBatcher {
+	if batchSize <= 0 {
+		batchSize = defaultExportBatchSize
+	}
+	if queueSize <= 0 {
+		queueSize = defaultExportQueueSize
+	}
+	if interval <= 0 {
+		interval = defaultExportIntervalMs * time.Millisecond
+	}
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	b := &spanBatcher{
+		kind:       kind,
+		queue:      make(chan spanRecord, queueSize),
+		send:       send,
+		batchSize:  batchSize,
+		interval:   interval,
+		maxRetries: maxRetries,
+		logger:     logger,
+	}
+	go b.run(ctx)
+	return b
+}
+
+func (b *spanBatcher) enqueue(span spanRecord) {
+	select {
+	case b.queue <- span:
+	default:
+		atomic.AddInt64(&b.dropped, 1)
+	}
+}
+
+func (b *spanBatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	batch := make([]spanRecord, 0, b.batchSize)
+	flush := func() {
+		b.reportDropped()
+		if len(batch) == 0 {
+			return
+		}
+		b.sendWithRetry(ctx, batch)
+		batch = make([]spanRecord, 0, b.batchSize)
+	}
+
+	for {
+		select {
+		case span := <-b.queue:
+			batch = append(batch, span)
+			if len(batch) >= b.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			// middleware is going away, send what we have left
+			for {
+				select {
+				case span := <-b.queue:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (b *spanBatcher) sendWithRetry(ctx context.Context, batch []spanRecord) {
+	backoff := exportInitialBackoff
+	for attempt := 0; ; attempt++ {
+		err := b.send(context.WithoutCancel(ctx), batch)
+		if err == nil {
+			atomic.AddInt64(&b.exported, int64(len(batch)))
+			return
+		}
+		if attempt >= b.maxRetries || !isRetryableExportError(err) || ctx.Err() != nil {
+			atomic.AddInt64(&b.failed, int64(len(batch)))
+			b.logger.Warn("span export failed", slog.String("exporter", b.kind), slog.Int("spans", len(batch)),
+				slog.Int("attempts", attempt+1), slog.String("error", err.Error()))
+			return
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		backoff *= 2
+		if backoff > exportMaxBackoff {
+			backoff = exportMaxBackoff
+		}
+	}
+}
+
+// reportDropped logs how many spans were dropped for a full queue since the last report.
+func (b *spanBatcher) reportDropped() {
+	dropped := atomic.LoadInt64(&b.dropped)
+	if dropped == b.reported {
+		return
+	}
+	b.logger.Warn("span export queue full, spans dropped", slog.String("exporter", b.kind), slog.Int64("spans", dropped-b.reported), slog.Int64("total", dropped))
+	b.reported = dropped
+}
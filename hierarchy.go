@@ -0,0 +1,35 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	hierarchySeparator        = "."
+	defaultHierarchyMaxLength = maxIncomingTraceIdLength
+	defaultHierarchyMaxDepth  = 8
+)
+
+// extendTraceValue appends a short segment for this hop to an incoming
+// hierarchical ID (<root>.<hop>.<hop>), unless that would exceed the configured
+// length or depth, in which case the caller should start a fresh root.
+func (t *TraceIDHeader) extendTraceValue(value string) (string, bool) {
+	if strings.Count(value, hierarchySeparator)+1 > t.hierarchyMaxDepth {
+		return "", false
+	}
+	var segment [4]byte
+	_, _ = rand.Read(segment[:])
+	extended := value + hierarchySeparator + hex.EncodeToString(segment[:])
+	if len(extended) > t.hierarchyMaxLength {
+		return "", false
+	}
+	return extended, true
+}
+
+// hierarchyRoot is the root ID of a hierarchical value, which is what we can parse.
+func hierarchyRoot(raw string) string {
+	root, _, _ := strings.Cut(raw, hierarchySeparator)
+	return root
+}
@@ -0,0 +1,89 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestHierarchicalTraceIds(t *testing.T) {
+	const root = "0191c5a2-3f7b-7cc3-9f3e-3a1b2c3d4e5f"
+
+	tests := []struct {
+		name       string
+		config     *Config
+		incoming   string
+		wantSource string
+		wantMatch  string
+	}{
+		{
+			name:       "fresh root",
+			config:     &Config{Hierarchical: true, TrustAllIPs: true},
+			wantSource: TraceIDSourceGenerated,
+			wantMatch:  `^[0-9a-f-]{36}$`,
+		},
+		{
+			name:       "first hop",
+			config:     &Config{Hierarchical: true, TrustAllIPs: true},
+			incoming:   root,
+			wantSource: TraceIDSourcePropagated,
+			wantMatch:  `^` + root + `\.[0-9a-f]{8}$`,
+		},
+		{
+			name:       "further hop",
+			config:     &Config{Hierarchical: true, TrustAllIPs: true},
+			incoming:   root + ".aaaaaaaa",
+			wantSource: TraceIDSourcePropagated,
+			wantMatch:  `^` + root + `\.aaaaaaaa\.[0-9a-f]{8}$`,
+		},
+		{
+			name:       "too deep",
+			config:     &Config{Hierarchical: true, TrustAllIPs: true, HierarchyMaxDepth: 1},
+			incoming:   root + ".aaaaaaaa",
+			wantSource: TraceIDSourceGenerated,
+			wantMatch:  `^[0-9a-f-]{36}$`,
+		},
+		{
+			name:       "too long",
+			config:     &Config{Hierarchical: true, TrustAllIPs: true, HierarchyMaxLength: 40},
+			incoming:   root,
+			wantSource: TraceIDSourceGenerated,
+			wantMatch:  `^[0-9a-f-]{36}$`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var got TraceID
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got, _ = TraceIDFromContext(req.Context())
+			})
+			handler, err := New(ctx, next, tt.config, "trace-id-test")
+			if err != nil {
+				t.Fatalf("error creating new plugin instance: %+v", err)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+			if err != nil {
+				t.Fatalf("error with request: %+v", err)
+			}
+			if tt.incoming != "" {
+				req.Header.Set(defaultHeaderName, tt.incoming)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if got.Source != tt.wantSource {
+				t.Fatalf("wanted source %q, got %q", tt.wantSource, got.Source)
+			}
+			if !regexp.MustCompile(tt.wantMatch).MatchString(got.Value) {
+				t.Fatalf("value %q does not match %s", got.Value, tt.wantMatch)
+			}
+			if got.Format != TraceIDFormatUUID || !strings.HasPrefix(got.Value, got.UUID.String()) {
+				t.Fatalf("root of %q should be parsed, got %+v", got.Value, got)
+			}
+		})
+	}
+}
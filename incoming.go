@@ -0,0 +1,51 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// incomingTraceIds lists the trace IDs the client sent us, in order of
+// preference: our header, then the query parameter, then the cookie.
+func (t *TraceIDHeader) incomingTraceIds(req *http.Request) []string {
+	var incoming []string
+	if v := req.Header.Get(t.headerName); v != "" {
+		incoming = append(incoming, v)
+	}
+	if t.traceIdQueryParam != "" {
+		if v := req.URL.Query().Get(t.traceIdQueryParam); v != "" {
+			incoming = append(incoming, v)
+		}
+	}
+	if t.traceIdCookie != "" {
+		if cookie, err := req.Cookie(t.traceIdCookie); err == nil && cookie.Value != "" {
+			incoming = append(incoming, cookie.Value)
+		}
+	}
+	return incoming
+}
+
+// stripQueryParam removes every occurrence of name from the query string,
+// leaving the other parameters untouched and in order.
+func stripQueryParam(req *http.Request, name string) {
+	if req.URL.RawQuery == "" {
+		return
+	}
+	parts := strings.Split(req.URL.RawQuery, "&")
+	kept := parts[:0]
+	for _, part := range parts {
+		key, _, _ := strings.Cut(part, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil && unescaped == name {
+			continue
+		}
+		kept = append(kept, part)
+	}
+	if len(kept) == len(parts) {
+		return
+	}
+	req.URL.RawQuery = strings.Join(kept, "&")
+	if req.RequestURI != "" {
+		req.RequestURI = req.URL.RequestURI()
+	}
+}
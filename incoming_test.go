@@ -0,0 +1,97 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIncomingTraceIdFromQueryOrCookie(t *testing.T) {
+	const clientId = "0191c5a2-3f7b-7cc3-9f3e-3a1b2c3d4e5f"
+
+	tests := []struct {
+		name       string
+		config     *Config
+		url        string
+		cookie     string
+		wantValue  string
+		wantSource string
+		wantQuery  string
+	}{
+		{
+			name:       "query parameter from trusted client",
+			config:     &Config{TrustAllIPs: true, TraceIdQueryParam: "traceId"},
+			url:        "http://localhost/pixel.gif?a=1&traceId=" + clientId + "&b=2",
+			wantValue:  clientId,
+			wantSource: TraceIDSourcePropagated,
+			wantQuery:  "a=1&traceId=" + clientId + "&b=2",
+		},
+		{
+			name:       "query parameter stripped",
+			config:     &Config{TrustAllIPs: true, TraceIdQueryParam: "traceId", StripQueryParam: true},
+			url:        "http://localhost/pixel.gif?a=1&traceId=" + clientId + "&b=2",
+			wantValue:  clientId,
+			wantSource: TraceIDSourcePropagated,
+			wantQuery:  "a=1&b=2",
+		},
+		{
+			name:       "query parameter from untrusted client is stripped but ignored",
+			config:     &Config{TraceIdQueryParam: "traceId", StripQueryParam: true},
+			url:        "http://localhost/pixel.gif?traceId=" + clientId,
+			wantSource: TraceIDSourceGenerated,
+			wantQuery:  "",
+		},
+		{
+			name:       "cookie from trusted client",
+			config:     &Config{TrustAllIPs: true, TraceIdCookie: "tid"},
+			url:        "http://localhost/",
+			cookie:     clientId,
+			wantValue:  clientId,
+			wantSource: TraceIDSourcePropagated,
+		},
+		{
+			name:       "invalid query value falls back to cookie",
+			config:     &Config{TrustAllIPs: true, TraceIdQueryParam: "traceId", TraceIdCookie: "tid"},
+			url:        "http://localhost/?traceId=has%20space",
+			cookie:     clientId,
+			wantValue:  clientId,
+			wantSource: TraceIDSourcePropagated,
+			wantQuery:  "traceId=has%20space",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var got TraceID
+			var gotQuery string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got, _ = TraceIDFromContext(req.Context())
+				gotQuery = req.URL.RawQuery
+			})
+			handler, err := New(ctx, next, tt.config, "trace-id-test")
+			if err != nil {
+				t.Fatalf("error creating new plugin instance: %+v", err)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatalf("error with request: %+v", err)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "tid", Value: tt.cookie})
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if got.Source != tt.wantSource {
+				t.Fatalf("wanted source %q, got %q", tt.wantSource, got.Source)
+			}
+			if tt.wantValue != "" && got.Value != tt.wantValue {
+				t.Fatalf("wanted value %q, got %q", tt.wantValue, got.Value)
+			}
+			if gotQuery != tt.wantQuery {
+				t.Fatalf("wanted query %q upstream, got %q", tt.wantQuery, gotQuery)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"errors"
+	"math/big"
+	"time"
+)
+
+const (
+	ksuidEncodedSize = 27
+	ksuidEpoch       = 1400000000 // KSUID timestamps count seconds from here, not 1970
+	ksuidBase62      = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// KSUID is a K-Sortable Unique IDentifier as made by github.com/segmentio/ksuid:
+// a 32 bit timestamp in seconds since ksuidEpoch, followed by 128 random bits.
+// We never generate them, but accept them from trusted clients.
+type KSUID [20]byte
+
+var errInvalidKSUID = errors.New("invalid KSUID")
+
+// parseKSUID decodes the 27 character base62 form of a KSUID.
+func parseKSUID(s string) (KSUID, error) {
+	var id KSUID
+	if len(s) != ksuidEncodedSize {
+		return id, errInvalidKSUID
+	}
+	n := new(big.Int)
+	base := big.NewInt(62)
+	for i := 0; i < len(s); i++ {
+		digit := -1
+		for j := 0; j < len(ksuidBase62); j++ {
+			if ksuidBase62[j] == s[i] {
+				digit = j
+				break
+			}
+		}
+		if digit < 0 {
+			return id, errInvalidKSUID
+		}
+		n.Mul(n, base).Add(n, big.NewInt(int64(digit)))
+	}
+	if n.BitLen() > len(id)*8 {
+		return id, errInvalidKSUID
+	}
+	n.FillBytes(id[:])
+	return id, nil
+}
+
+// Time returns the (second precision) time embedded in the KSUID.
+func (id KSUID) Time() time.Time {
+	ts := uint32(id[0])<<24 | uint32(id[1])<<16 | uint32(id[2])<<8 | uint32(id[3])
+	return time.Unix(int64(ts)+ksuidEpoch, 0).UTC()
+}
@@ -0,0 +1,43 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKSUID(t *testing.T) {
+	// example from github.com/segmentio/ksuid
+	id, err := parseKSUID("0ujtsYcgvSTl8PAuAdqWYSMnLOv")
+	if err != nil {
+		t.Fatalf("error parsing KSUID: %v", err)
+	}
+	if got := strings.ToUpper(hex.EncodeToString(id[4:])); got != "B5A1CD34B5F99D1154FB6853345C9735" {
+		t.Fatalf("unexpected payload %s", got)
+	}
+	if got := id.Time(); !got.Equal(time.Date(2017, 10, 10, 4, 0, 47, 0, time.UTC)) {
+		t.Fatalf("unexpected time %v", got)
+	}
+
+	for _, bad := range []string{
+		"0ujtsYcgvSTl8PAuAdqWYSMnLO",  // too short
+		"0ujtsYcgvSTl8PAuAdqWYSMnLO-", // not base62
+		"zzzzzzzzzzzzzzzzzzzzzzzzzzz", // more than 160 bits
+	} {
+		if _, err := parseKSUID(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestParseTraceValueKSUID(t *testing.T) {
+	testMe := &TraceIDHeader{valuePrefix: "myorg-"}
+	traceID := testMe.parseTraceValue("myorg-0ujtsYcgvSTl8PAuAdqWYSMnLOv", TraceIDSourcePropagated)
+	if traceID.Format != TraceIDFormatKSUID {
+		t.Fatalf("expected a KSUID, got format %q", traceID.Format)
+	}
+	if ts, ok := traceID.Time(); !ok || ts.Unix() != 107608047+ksuidEpoch {
+		t.Fatalf("unexpected KSUID time %v", ts)
+	}
+}
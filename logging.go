@@ -0,0 +1,97 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+
+	logOutputStdout = "stdout"
+	logOutputStderr = "stderr"
+)
+
+// openLogOutput resolves logOutput to stdout, stderr or a file opened for
+// appending. The file is closed once ctx is done, Traefik creates a new
+// instance (and opens the file again) on every configuration reload.
+func openLogOutput(ctx context.Context, output string) (io.Writer, error) {
+	switch output {
+	case "", logOutputStderr:
+		return os.Stderr, nil
+	case logOutputStdout:
+		return os.Stdout, nil
+	}
+	f, err := os.OpenFile(output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("can not open log output: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		_ = f.Close()
+	}()
+	return f, nil
+}
+
+// closeLogOutput closes a log file openLogOutput opened, for when New fails
+// after opening it and ctx may never be done.
+func closeLogOutput(out io.Writer) {
+	if f, ok := out.(*os.File); ok && f != os.Stdout && f != os.Stderr {
+		_ = f.Close()
+	}
+}
+
+// newLogger builds the slog logger for one middleware instance, every line
+// carries the middleware name so several instances can share an output.
+func newLogger(config *Config, name string, out io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	switch strings.ToLower(config.LogLevel) {
+	case "debug":
+		level = slog.LevelDebug
+	case "", "info":
+		level = slog.LevelInfo
+	case "warn", "warning":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		return nil, fmt.Errorf("only log level of debug, info, warn, or error is supported")
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(config.LogFormat) {
+	case "", logFormatText:
+		handler = slog.NewTextHandler(out, opts)
+	case logFormatJSON:
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		return nil, fmt.Errorf("only log format of text or json is supported")
+	}
+
+	return slog.New(handler).With("middleware", name), nil
+}
+
+// requestLogAttrs are the fields every per-request log line carries.
+func requestLogAttrs(req *http.Request, traceValue string) []any {
+	attrs := []any{
+		slog.String("traceId", traceValue),
+		slog.String("method", req.Method),
+		slog.String("host", req.Host),
+		slog.String("path", req.URL.Path),
+		slog.String("remoteAddr", req.RemoteAddr),
+	}
+	if traceID, ok := TraceIDFromContext(req.Context()); ok && traceID.SpanID != "" {
+		attrs = append(attrs, slog.String("spanId", traceID.SpanID))
+		if traceID.ParentSpanID != "" {
+			attrs = append(attrs, slog.String("parentSpanId", traceID.ParentSpanID))
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,141 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerboseJSONLogging(t *testing.T) {
+	ctx := context.Background()
+	logFile := filepath.Join(t.TempDir(), "trace.log")
+
+	config := &Config{
+		Verbose:   true,
+		LogFormat: "JSON",
+		LogOutput: logFile,
+	}
+	handler, err := New(ctx, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), config, "trace-id-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://example.com/some/path", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+	req.RemoteAddr = "192.0.2.1:1234"
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("error reading log output: %+v", err)
+	}
+	var line map[string]any
+	if err := json.Unmarshal([]byte(strings.TrimSpace(string(data))), &line); err != nil {
+		t.Fatalf("log output is not a single JSON line: %q", data)
+	}
+	for key, want := range map[string]string{
+		"traceId":    req.Header.Get(defaultHeaderName),
+		"method":     http.MethodPost,
+		"host":       "example.com",
+		"path":       "/some/path",
+		"remoteAddr": "192.0.2.1:1234",
+		"middleware": "trace-id-test",
+		"source":     TraceIDSourceGenerated,
+	} {
+		if line[key] != want {
+			t.Fatalf("wanted %s=%q, got %v", key, want, line[key])
+		}
+	}
+}
+
+func TestLogLevelFiltersVerbose(t *testing.T) {
+	ctx := context.Background()
+	logFile := filepath.Join(t.TempDir(), "trace.log")
+
+	config := &Config{
+		Verbose:   true,
+		LogLevel:  "warn",
+		LogOutput: logFile,
+	}
+	handler, err := New(ctx, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), config, "trace-id-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("error reading log output: %+v", err)
+	}
+	if len(data) != 0 {
+		t.Fatalf("expected no info lines at warn level, got %q", data)
+	}
+}
+
+func TestNewRejectsUnknownLogSettings(t *testing.T) {
+	for _, config := range []*Config{{LogFormat: "xml"}, {LogLevel: "trace"}} {
+		if _, err := New(context.Background(), http.NotFoundHandler(), config, "trace-id-test"); err == nil {
+			t.Fatalf("expected an error for %+v", config)
+		}
+	}
+}
+
+func TestLogOutputClosedWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	out, err := openLogOutput(ctx, filepath.Join(t.TempDir(), "trace.log"))
+	if err != nil {
+		t.Fatalf("error opening log output: %v", err)
+	}
+	if _, err := out.Write([]byte("before\n")); err != nil {
+		t.Fatalf("error writing to log output: %v", err)
+	}
+
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := out.Write([]byte("after\n")); errors.Is(err, os.ErrClosed) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("log output file was not closed when the context was done")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLogOutputClosedWhenNewFails(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("can not list open files here")
+	}
+	ctx := context.Background()
+
+	logFile := filepath.Join(t.TempDir(), "trace.log")
+	config := &Config{
+		LogOutput:    logFile,
+		EventLogFile: filepath.Join(t.TempDir(), "missing", "events.log"),
+	}
+	if _, err := New(ctx, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), config, "trace-id-test"); err == nil {
+		t.Fatal("expected an error for an event log in a missing directory")
+	}
+
+	fds, _ := os.ReadDir("/proc/self/fd")
+	for _, fd := range fds {
+		if target, _ := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); target == logFile {
+			t.Fatal("log output file is still open after New failed")
+		}
+	}
+}
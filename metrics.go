@@ -0,0 +1,246 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	metricRequests          = "traceid_requests_total"
+	metricIncomingIds       = "traceid_incoming_ids_total"
+	metricGeneratorFailures = "traceid_generator_failures_total"
+	metricRequestDuration   = "traceid_request_duration_seconds"
+	metricExportedSpans     = "traceid_exported_spans_total"
+	metricEventLogDropped   = "traceid_event_log_dropped_total"
+	metricStaleIds          = "traceid_stale_ids_total"
+
+	incomingOutcomeAccepted  = "accepted"
+	incomingOutcomeInvalid   = "invalid"
+	incomingOutcomeUntrusted = "untrusted"
+	incomingOutcomeTooDeep   = "too_deep"
+)
+
+var metricHelp = map[string]string{
+	metricRequests:          "Requests that got a trace ID, by generator and whether it was generated or propagated.",
+	metricIncomingIds:       "Trace IDs sent by clients, by outcome.",
+	metricGeneratorFailures: "Times the trace ID generator failed or panicked.",
+	metricRequestDuration:   "Time from receiving the request until upstream finished answering.",
+	metricExportedSpans:     "Spans handed to exporters, by exporter and outcome.",
+	metricEventLogDropped:   "Event log lines dropped because the write queue was full.",
+	metricStaleIds:          "Propagated trace IDs whose embedded time was too old or in the future, by outcome.",
+}
+
+// same as the Prometheus client default buckets
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// metricsRegistry holds the metrics of all middleware instances with metrics
+// turned on, so scraping the metrics path of any of them shows them all.
+type metricsRegistry struct {
+	mu         sync.Mutex
+	counters   map[string]map[string]float64 // metric -> labels -> value
+	histograms map[string]map[string]*histogram
+	collectors map[string]func(add func(metric, labels string, value float64)) // per middleware name
+}
+
+var pluginMetrics = newMetricsRegistry()
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		counters:   map[string]map[string]float64{},
+		histograms: map[string]map[string]*histogram{},
+		collectors: map[string]func(add func(metric, labels string, value float64)){},
+	}
+}
+
+// metricLabels renders label pairs as {k1="v1",k2="v2"}.
+func metricLabels(pairs ...string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// inc is a no-op on a nil registry, which is what instances without metrics have.
+func (m *metricsRegistry) inc(metric string, labels ...string) {
+	if m == nil {
+		return
+	}
+	key := metricLabels(labels...)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series, ok := m.counters[metric]
+	if !ok {
+		series = map[string]float64{}
+		m.counters[metric] = series
+	}
+	series[key]++
+}
+
+func (m *metricsRegistry) observe(metric string, value float64, labels ...string) {
+	if m == nil {
+		return
+	}
+	key := metricLabels(labels...)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series, ok := m.histograms[metric]
+	if !ok {
+		series = map[string]*histogram{}
+		m.histograms[metric] = series
+	}
+	h, ok := series[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		series[key] = h
+	}
+	for i, bound := range durationBuckets {
+		if value <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// setCollector registers values that are read at scrape time, replacing the
+// ones of a previous instance with the same name (e.g. after a config reload).
+func (m *metricsRegistry) setCollector(name string, collect func(add func(metric, labels string, value float64))) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collectors[name] = collect
+}
+
+// writeTo renders everything in the Prometheus text exposition format.
+func (m *metricsRegistry) writeTo(w io.Writer) {
+	m.mu.Lock()
+	counters := map[string]map[string]float64{}
+	for metric, series := range m.counters {
+		counters[metric] = map[string]float64{}
+		for labels, value := range series {
+			counters[metric][labels] = value
+		}
+	}
+	var collectors []func(add func(metric, labels string, value float64))
+	for _, collect := range m.collectors {
+		collectors = append(collectors, collect)
+	}
+	var out strings.Builder
+	for _, metric := range sortedKeys(m.histograms) {
+		series := m.histograms[metric]
+		writeMetricHeader(&out, metric, "histogram")
+		for _, labels := range sortedKeys(series) {
+			h := series[labels]
+			inner := strings.TrimSuffix(strings.TrimPrefix(labels, "{"), "}")
+			if inner != "" {
+				inner += ","
+			}
+			var cumulative uint64
+			for i, bound := range durationBuckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(&out, "%s_bucket{%sle=\"%s\"} %d\n", metric, inner, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+			}
+			fmt.Fprintf(&out, "%s_bucket{%sle=\"+Inf\"} %d\n", metric, inner, h.count)
+			fmt.Fprintf(&out, "%s_sum%s %s\n", metric, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+			fmt.Fprintf(&out, "%s_count%s %d\n", metric, labels, h.count)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, collect := range collectors {
+		collect(func(metric, labels string, value float64) {
+			if counters[metric] == nil {
+				counters[metric] = map[string]float64{}
+			}
+			counters[metric][labels] += value
+		})
+	}
+	for _, metric := range sortedKeys(counters) {
+		writeMetricHeader(&out, metric, "counter")
+		for _, labels := range sortedKeys(counters[metric]) {
+			fmt.Fprintf(&out, "%s%s %s\n", metric, labels, strconv.FormatFloat(counters[metric][labels], 'g', -1, 64))
+		}
+	}
+	_, _ = io.WriteString(w, out.String())
+}
+
+func writeMetricHeader(out *strings.Builder, metric, kind string) {
+	if help, ok := metricHelp[metric]; ok {
+		fmt.Fprintf(out, "# HELP %s %s\n", metric, help)
+	}
+	fmt.Fprintf(out, "# TYPE %s %s\n", metric, kind)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// registerMetricCollectors exposes the exporter and event log counters, which live in atomics.
+func (t *TraceIDHeader) registerMetricCollectors() {
+	exporters := t.spanExporters
+	eventLog := t.eventLog
+	t.metrics.setCollector(t.name, func(add func(metric, labels string, value float64)) {
+		for _, b := range exporters {
+			add(metricExportedSpans, metricLabels("middleware", t.name, "exporter", b.kind, "outcome", "exported"), float64(atomic.LoadInt64(&b.exported)))
+			add(metricExportedSpans, metricLabels("middleware", t.name, "exporter", b.kind, "outcome", "dropped"), float64(atomic.LoadInt64(&b.dropped)))
+			add(metricExportedSpans, metricLabels("middleware", t.name, "exporter", b.kind, "outcome", "failed"), float64(atomic.LoadInt64(&b.failed)))
+		}
+		if eventLog != nil {
+			add(metricEventLogDropped, metricLabels("middleware", t.name), float64(atomic.LoadInt64(&eventLog.dropped)))
+		}
+	})
+}
+
+func (t *TraceIDHeader) countIncoming(outcome string) {
+	t.metrics.inc(metricIncomingIds, "middleware", t.name, "outcome", outcome)
+}
+
+func (t *TraceIDHeader) countGeneratorFailure() {
+	t.metrics.inc(metricGeneratorFailures, "middleware", t.name, "generator", t.uuidGen)
+}
+
+func (t *TraceIDHeader) recordRequest(traceID TraceID, duration time.Duration) {
+	if t.metrics == nil {
+		return
+	}
+	t.metrics.inc(metricRequests, "middleware", t.name, "generator", t.uuidGen, "source", traceID.Source)
+	t.metrics.observe(metricRequestDuration, duration.Seconds(), "middleware", t.name, "source", traceID.Source)
+}
+
+// isMetricsRequest is a GET for the configured metrics path.
+func (t *TraceIDHeader) isMetricsRequest(req *http.Request) bool {
+	return t.metricsPath != "" && req.URL.Path == t.metricsPath && (req.Method == http.MethodGet || req.Method == http.MethodHead)
+}
+
+func (t *TraceIDHeader) serveMetrics(rw http.ResponseWriter) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	t.metrics.writeTo(rw)
+}
@@ -0,0 +1,98 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	nextCalls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		nextCalls++
+	})
+	config := &Config{MetricsPath: "/metrics", TrustNetworks: []string{"10.0.0.0/8"}}
+	handler, err := New(ctx, next, config, "metrics-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+	withOwnMetrics(handler)
+
+	requests := []struct {
+		remoteAddr string
+		traceID    string
+	}{
+		{remoteAddr: "10.1.2.3:1234"},
+		{remoteAddr: "10.1.2.3:1234", traceID: "from-client"},
+		{remoteAddr: "10.1.2.3:1234", traceID: "bad\x01value"},
+		{remoteAddr: "192.0.2.1:1234", traceID: "from-outside"},
+	}
+	for _, r := range requests {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+		if err != nil {
+			t.Fatalf("error with request: %+v", err)
+		}
+		req.RemoteAddr = r.remoteAddr
+		if r.traceID != "" {
+			req.Header.Set("X-Trace-Id", r.traceID)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/metrics", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if nextCalls != len(requests) {
+		t.Fatalf("metrics request should not be passed upstream, next was called %d times", nextCalls)
+	}
+	resp := recorder.Result()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+	body, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{
+		`traceid_requests_total{middleware="metrics-test",generator="4",source="generated"} 3`,
+		`traceid_requests_total{middleware="metrics-test",generator="4",source="propagated"} 1`,
+		`traceid_incoming_ids_total{middleware="metrics-test",outcome="accepted"} 1`,
+		`traceid_incoming_ids_total{middleware="metrics-test",outcome="invalid"} 1`,
+		`traceid_incoming_ids_total{middleware="metrics-test",outcome="untrusted"} 1`,
+		`traceid_request_duration_seconds_bucket{middleware="metrics-test",source="generated",le="+Inf"} 3`,
+		`traceid_request_duration_seconds_count{middleware="metrics-test",source="propagated"} 1`,
+		`# TYPE traceid_request_duration_seconds histogram`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("metrics are missing %q:\n%s", want, body)
+		}
+	}
+}
+
+// withOwnMetrics moves an instance off the process-wide registry, so counts
+// don't depend on what other tests (or earlier -count runs) did.
+func withOwnMetrics(handler http.Handler) {
+	tIDHdr := handler.(*TraceIDHeader)
+	tIDHdr.metrics = newMetricsRegistry()
+	tIDHdr.registerMetricCollectors()
+}
+
+func TestMetricLabelsEscaping(t *testing.T) {
+	got := metricLabels("middleware", `a"b\c`+"\n")
+	if got != `{middleware="a\"b\\c\n"}` {
+		t.Fatalf("unexpected labels %s", got)
+	}
+}
+
+func TestNewRejectsRelativeMetricsPath(t *testing.T) {
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{MetricsPath: "metrics"}, "trace-id-test")
+	if err == nil {
+		t.Fatal("expected an error for a metrics path without a leading /")
+	}
+}
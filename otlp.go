@@ -0,0 +1,262 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	otlpProtocolJSON     = "json"
+	otlpProtocolProtobuf = "protobuf"
+
+	otlpSpanKindServer  = 2
+	otlpStatusCodeUnset = 0
+	otlpStatusCodeError = 2
+)
+
+// otlpAttr is a span or resource attribute, either a string or an int.
+type otlpAttr struct {
+	key   string
+	str   string
+	num   int64
+	isNum bool
+}
+
+func spanAttrs(span spanRecord) []otlpAttr {
+	attrs := []otlpAttr{
+		{key: "http.request.method", str: span.Method},
+		{key: "url.scheme", str: span.Scheme},
+		{key: "url.path", str: span.Path},
+		{key: "server.address", str: span.Host},
+		{key: "http.response.status_code", num: int64(span.Status), isNum: true},
+		{key: "http.response.body.size", num: span.Bytes, isNum: true},
+		{key: "trace_id.value", str: span.TraceValue},
+		{key: "trace_id.source", str: span.Source},
+	}
+	if span.RemoteAddr != "" {
+		attrs = append(attrs, otlpAttr{key: "client.address", str: span.RemoteAddr})
+	}
+	if span.UserAgent != "" {
+		attrs = append(attrs, otlpAttr{key: "user_agent.original", str: span.UserAgent})
+	}
+	return attrs
+}
+
+func spanStatusCode(span spanRecord) int {
+	if span.Status >= 500 {
+		return otlpStatusCodeError // server spans only count 5xx as errors
+	}
+	return otlpStatusCodeUnset
+}
+
+// encodeOTLPJSON builds an ExportTraceServiceRequest in the OTLP/JSON mapping,
+// where IDs are hex strings and 64-bit integers are strings.
+func encodeOTLPJSON(serviceName string, batch []spanRecord) ([]byte, error) {
+	type anyValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	}
+	type keyValue struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+	type status struct {
+		Code int `json:"code"`
+	}
+	type span struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes"`
+		Status            status     `json:"status"`
+	}
+	convert := func(attrs []otlpAttr) []keyValue {
+		kvs := make([]keyValue, 0, len(attrs))
+		for _, a := range attrs {
+			kv := keyValue{Key: a.key}
+			if a.isNum {
+				v := strconv.FormatInt(a.num, 10)
+				kv.Value.IntValue = &v
+			} else {
+				v := a.str
+				kv.Value.StringValue = &v
+			}
+			kvs = append(kvs, kv)
+		}
+		return kvs
+	}
+
+	spans := make([]span, 0, len(batch))
+	for _, s := range batch {
+		if s.TraceHex == "" {
+			continue
+		}
+		spans = append(spans, span{
+			TraceID:           s.TraceHex,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentSpanID,
+			Name:              s.Name,
+			Kind:              otlpSpanKindServer,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        convert(spanAttrs(s)),
+			Status:            status{Code: spanStatusCode(s)},
+		})
+	}
+
+	return json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": convert([]otlpAttr{{key: "service.name", str: serviceName}})},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": pluginScopeName},
+				"spans": spans,
+			}},
+		}},
+	})
+}
+
+const pluginScopeName = "github.com/cdwiegand/traefik-add-trace-id-header-2"
+
+// protobuf wire format helpers, just enough for an ExportTraceServiceRequest
+func protoVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func protoTag(b []byte, field int, wireType int) []byte {
+	return protoVarint(b, uint64(field<<3|wireType))
+}
+
+func protoBytes(b []byte, field int, v []byte) []byte {
+	b = protoTag(b, field, 2)
+	b = protoVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func protoString(b []byte, field int, v string) []byte {
+	return protoBytes(b, field, []byte(v))
+}
+
+func protoUint(b []byte, field int, v uint64) []byte {
+	return protoVarint(protoTag(b, field, 0), v)
+}
+
+func protoFixed64(b []byte, field int, v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(protoTag(b, field, 1), v)
+}
+
+func protoKeyValue(a otlpAttr) []byte {
+	var value []byte
+	if a.isNum {
+		value = protoUint(nil, 3, uint64(a.num)) // int_value
+	} else {
+		value = protoString(nil, 1, a.str) // string_value
+	}
+	kv := protoString(nil, 1, a.key)
+	return protoBytes(kv, 2, value)
+}
+
+// encodeOTLPProtobuf builds the same request as encodeOTLPJSON in protobuf encoding.
+func encodeOTLPProtobuf(serviceName string, batch []spanRecord) ([]byte, error) {
+	scopeSpans := protoBytes(nil, 1, protoString(nil, 1, pluginScopeName)) // scope
+	for _, s := range batch {
+		if s.TraceHex == "" {
+			continue
+		}
+		traceID, err := hex.DecodeString(s.TraceHex)
+		if err != nil {
+			return nil, err
+		}
+		spanID, err := hex.DecodeString(s.SpanID)
+		if err != nil {
+			return nil, err
+		}
+
+		var span []byte
+		span = protoBytes(span, 1, traceID)
+		span = protoBytes(span, 2, spanID)
+		if s.ParentSpanID != "" {
+			if parentID, err := hex.DecodeString(s.ParentSpanID); err == nil {
+				span = protoBytes(span, 4, parentID)
+			}
+		}
+		span = protoString(span, 5, s.Name)
+		span = protoUint(span, 6, otlpSpanKindServer)
+		span = protoFixed64(span, 7, uint64(s.Start.UnixNano()))
+		span = protoFixed64(span, 8, uint64(s.End.UnixNano()))
+		for _, a := range spanAttrs(s) {
+			span = protoBytes(span, 9, protoKeyValue(a))
+		}
+		if code := spanStatusCode(s); code != otlpStatusCodeUnset {
+			span = protoBytes(span, 15, protoUint(nil, 3, uint64(code)))
+		}
+		scopeSpans = protoBytes(scopeSpans, 2, span)
+	}
+
+	resource := protoBytes(nil, 1, protoKeyValue(otlpAttr{key: "service.name", str: serviceName}))
+	resourceSpans := protoBytes(nil, 1, resource)
+	resourceSpans = protoBytes(resourceSpans, 2, scopeSpans)
+	return protoBytes(nil, 1, resourceSpans), nil
+}
+
+// sendOTLP POSTs one batch to the collector.
+func (t *TraceIDHeader) sendOTLP(ctx context.Context, batch []spanRecord) error {
+	var body []byte
+	var err error
+	contentType := "application/json"
+	if t.otlpProtocol == otlpProtocolProtobuf {
+		contentType = "application/x-protobuf"
+		body, err = encodeOTLPProtobuf(t.serviceName, batch)
+	} else {
+		body, err = encodeOTLPJSON(t.serviceName, batch)
+	}
+	if err != nil {
+		return err
+	}
+	return t.postSpans(ctx, t.otlpEndpoint, contentType, body, t.otlpHeaders)
+}
+
+// postSpans does the actual HTTP POST to a span collector.
+func (t *TraceIDHeader) postSpans(ctx context.Context, url, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.exportClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &exportStatusError{status: resp.Status, code: resp.StatusCode}
+	}
+	return nil
+}
+
+func validateOTLP(config *Config) error {
+	switch strings.ToLower(config.OtlpProtocol) {
+	case "", otlpProtocolJSON, otlpProtocolProtobuf:
+		return nil
+	}
+	return fmt.Errorf("only otlp protocol of json or protobuf is supported")
+}
@@ -0,0 +1,214 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type collectedExport struct {
+	contentType string
+	auth        string
+	body        []byte
+}
+
+func newTestCollector(t *testing.T) (*httptest.Server, chan collectedExport) {
+	t.Helper()
+	received := make(chan collectedExport, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		received <- collectedExport{contentType: req.Header.Get("Content-Type"), auth: req.Header.Get("Authorization"), body: body}
+	}))
+	t.Cleanup(collector.Close)
+	return collector, received
+}
+
+func serveExportedRequest(t *testing.T, config *Config, status int) TraceID {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	var traceID TraceID
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		traceID, _ = TraceIDFromContext(req.Context())
+		rw.WriteHeader(status)
+	})
+	handler, err := New(ctx, next, config, "trace-id-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com/orders", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+	req.Header.Set("X-Span-Id", "00f067aa0ba902b7")
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	return traceID
+}
+
+func waitForExport(t *testing.T, received chan collectedExport) collectedExport {
+	t.Helper()
+	select {
+	case export := <-received:
+		return export
+	case <-time.After(5 * time.Second):
+		t.Fatal("collector did not receive any spans")
+	}
+	return collectedExport{}
+}
+
+func TestOTLPJSONExport(t *testing.T) {
+	collector, received := newTestCollector(t)
+	config := &Config{
+		OtlpEndpoint:    collector.URL + "/v1/traces",
+		OtlpHeaders:     map[string]string{"Authorization": "Bearer test"},
+		ExportBatchSize: 1,
+		SpanIds:         true,
+		TrustAllIPs:     true,
+		ServiceName:     "edge",
+	}
+	traceID := serveExportedRequest(t, config, http.StatusBadGateway)
+
+	export := waitForExport(t, received)
+	if export.contentType != "application/json" || export.auth != "Bearer test" {
+		t.Fatalf("unexpected export headers %+v", export)
+	}
+	var payload struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string `json:"key"`
+					Value struct {
+						StringValue string `json:"stringValue"`
+					} `json:"value"`
+				} `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Kind         int    `json:"kind"`
+					Status       struct {
+						Code int `json:"code"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(export.body, &payload); err != nil {
+		t.Fatalf("export is not JSON: %q", export.body)
+	}
+	if payload.ResourceSpans[0].Resource.Attributes[0].Value.StringValue != "edge" {
+		t.Fatalf("unexpected resource %s", export.body)
+	}
+	span := payload.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if span.TraceID != traceID.Hex() || span.SpanID != traceID.SpanID || span.ParentSpanID != "00f067aa0ba902b7" ||
+		span.Kind != otlpSpanKindServer || span.Status.Code != otlpStatusCodeError {
+		t.Fatalf("unexpected span %+v for trace %+v", span, traceID)
+	}
+}
+
+func TestOTLPProtobufExport(t *testing.T) {
+	collector, received := newTestCollector(t)
+	config := &Config{
+		OtlpEndpoint:    collector.URL + "/v1/traces",
+		OtlpProtocol:    "protobuf",
+		ExportBatchSize: 1,
+		SpanIds:         true,
+	}
+	traceID := serveExportedRequest(t, config, http.StatusOK)
+
+	export := waitForExport(t, received)
+	if export.contentType != "application/x-protobuf" {
+		t.Fatalf("unexpected content type %q", export.contentType)
+	}
+	traceBytes, _ := hex.DecodeString(traceID.Hex())
+	spanBytes, _ := hex.DecodeString(traceID.SpanID)
+	// trace_id (field 1) and span_id (field 2) of the span, length-delimited
+	if !bytes.Contains(export.body, append([]byte{0x0a, 16}, traceBytes...)) || !bytes.Contains(export.body, append([]byte{0x12, 8}, spanBytes...)) {
+		t.Fatalf("trace %s / span %s not found in protobuf export %x", traceID.Hex(), traceID.SpanID, export.body)
+	}
+}
+
+func TestProtoVarint(t *testing.T) {
+	for v, want := range map[uint64][]byte{0: {0x00}, 1: {0x01}, 300: {0xac, 0x02}} {
+		if got := protoVarint(nil, v); !bytes.Equal(got, want) {
+			t.Fatalf("varint %d: wanted %x, got %x", v, want, got)
+		}
+	}
+}
+
+func TestSamplerSkipsExport(t *testing.T) {
+	collector, received := newTestCollector(t)
+	config := &Config{
+		OtlpEndpoint:     collector.URL + "/v1/traces",
+		ExportBatchSize:  1,
+		ExportIntervalMs: 10,
+		Sampler:          "never",
+	}
+	serveExportedRequest(t, config, http.StatusOK)
+
+	select {
+	case export := <-received:
+		t.Fatalf("unsampled trace should not be exported, got %q", export.body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestExportedSpanMatchesTraceparent(t *testing.T) {
+	collector, received := newTestCollector(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := &Config{
+		OtlpEndpoint:        collector.URL + "/v1/traces",
+		ExportBatchSize:     1,
+		Sampler:             "always",
+		SamplingPropagation: []string{"traceparent"},
+		TraceResponse:       true,
+	}
+	var traceparent string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		traceparent = req.Header.Get("traceparent")
+	})
+	handler, err := New(ctx, next, config, "trace-id-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com/orders", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	var payload struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID string `json:"traceId"`
+					SpanID  string `json:"spanId"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	export := waitForExport(t, received)
+	if err := json.Unmarshal(export.body, &payload); err != nil {
+		t.Fatalf("export is not JSON: %q", export.body)
+	}
+	span := payload.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if want := "00-" + span.TraceID + "-" + span.SpanID + "-01"; traceparent != want {
+		t.Fatalf("upstream got traceparent %q, exported span was %q", traceparent, want)
+	}
+	if traceResponse := recorder.Header().Get("traceresponse"); traceResponse != traceparent {
+		t.Fatalf("traceresponse %q does not match traceparent %q", traceResponse, traceparent)
+	}
+}
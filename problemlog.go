@@ -0,0 +1,141 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusMatcher matches either one exact status code or a whole class ("5xx").
+type statusMatcher struct {
+	code  int // exact status code, 0 for a class
+	class int // 1-5 for 1xx-5xx, 0 for an exact code
+}
+
+func parseStatusMatchers(statuses []string) ([]statusMatcher, error) {
+	var matchers []statusMatcher
+	for _, s := range statuses {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" {
+			continue
+		}
+		if len(s) == 3 && strings.HasSuffix(s, "xx") && s[0] >= '1' && s[0] <= '5' {
+			matchers = append(matchers, statusMatcher{class: int(s[0] - '0')})
+			continue
+		}
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid status %q, expected a code like 429 or a class like 5xx", s)
+		}
+		matchers = append(matchers, statusMatcher{code: code})
+	}
+	return matchers, nil
+}
+
+func matchStatus(matchers []statusMatcher, status int) bool {
+	for _, m := range matchers {
+		if m.code == status || (m.class != 0 && status/100 == m.class) {
+			return true
+		}
+	}
+	return false
+}
+
+// logRateLimiter is a token bucket refilled at perSecond tokens per second, so
+// a burst of failing requests can't flood the log output.
+type logRateLimiter struct {
+	mu         sync.Mutex
+	perSecond  float64
+	tokens     float64
+	last       time.Time
+	suppressed int64
+}
+
+func newLogRateLimiter(perSecond int) *logRateLimiter {
+	if perSecond <= 0 {
+		return nil // unlimited
+	}
+	return &logRateLimiter{perSecond: float64(perSecond), tokens: float64(perSecond)}
+}
+
+// allow reports whether a line may be written now, and how many lines were
+// dropped since the last one that was allowed.
+func (l *logRateLimiter) allow(now time.Time) (bool, int64) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.perSecond
+		if l.tokens > l.perSecond {
+			l.tokens = l.perSecond
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		l.suppressed++
+		return false, 0
+	}
+	l.tokens--
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return true, suppressed
+}
+
+// logProblemRequest logs requests that were too slow or ended in one of the
+// configured statuses, with a timing breakdown and selected request headers.
+// nextStart is when we handed the request to next.
+func (t *TraceIDHeader) logProblemRequest(req *http.Request, rw *responseWriter, traceValue string, start, nextStart, end time.Time) {
+	total := end.Sub(start)
+	slow := t.slowRequestThreshold > 0 && total >= t.slowRequestThreshold
+	failed := matchStatus(t.logStatuses, rw.status)
+	if !slow && !failed {
+		return
+	}
+
+	allowed, suppressed := t.problemLogLimiter.allow(end)
+	if !allowed {
+		return
+	}
+
+	attrs := requestLogAttrs(req, traceValue)
+	attrs = append(attrs,
+		slog.Int("status", rw.status),
+		slog.Int64("bytes", rw.bytes),
+		slog.Bool("slow", slow),
+		slog.Float64("totalMs", durationMs(total)),
+		slog.Float64("pluginMs", durationMs(nextStart.Sub(start))),
+		slog.Float64("upstreamMs", durationMs(end.Sub(nextStart))),
+	)
+	if !rw.wroteHeaderAt.IsZero() {
+		attrs = append(attrs, slog.Float64("upstreamHeadersMs", durationMs(rw.wroteHeaderAt.Sub(nextStart))))
+	}
+	if len(t.logRequestHeaders) > 0 {
+		var headers []any
+		for _, name := range t.logRequestHeaders {
+			if v := req.Header.Values(name); len(v) > 0 {
+				headers = append(headers, slog.String(name, strings.Join(v, ", ")))
+			}
+		}
+		attrs = append(attrs, slog.Group("headers", headers...))
+	}
+	if suppressed > 0 {
+		attrs = append(attrs, slog.Int64("suppressed", suppressed))
+	}
+
+	if failed {
+		t.logger.Error("problem request", attrs...)
+	} else {
+		t.logger.Warn("slow request", attrs...)
+	}
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,102 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchStatus(t *testing.T) {
+	matchers, err := parseStatusMatchers([]string{"5xx", "429"})
+	if err != nil {
+		t.Fatalf("error parsing statuses: %+v", err)
+	}
+	for status, want := range map[int]bool{500: true, 503: true, 599: true, 429: true, 200: false, 404: false, 428: false} {
+		if got := matchStatus(matchers, status); got != want {
+			t.Fatalf("status %d: wanted %v, got %v", status, want, got)
+		}
+	}
+
+	for _, bad := range []string{"6xx", "abc", "42"} {
+		if _, err := parseStatusMatchers([]string{bad}); err == nil {
+			t.Fatalf("expected an error for status %q", bad)
+		}
+	}
+}
+
+func TestLogRateLimiter(t *testing.T) {
+	limiter := newLogRateLimiter(2)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.allow(now); !ok {
+			t.Fatalf("line %d should be allowed within the burst", i)
+		}
+	}
+	if ok, _ := limiter.allow(now); ok {
+		t.Fatal("third line in the same instant should be suppressed")
+	}
+	ok, suppressed := limiter.allow(now.Add(time.Second))
+	if !ok || suppressed != 1 {
+		t.Fatalf("wanted an allowed line reporting 1 suppressed, got %v/%d", ok, suppressed)
+	}
+
+	if ok, _ := newLogRateLimiter(0).allow(now); !ok {
+		t.Fatal("a disabled limiter should allow everything")
+	}
+}
+
+func TestProblemRequestLogging(t *testing.T) {
+	ctx := context.Background()
+	logFile := filepath.Join(t.TempDir(), "trace.log")
+
+	status := http.StatusOK
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(status)
+	})
+	config := &Config{
+		LogFormat:         "json",
+		LogOutput:         logFile,
+		LogStatuses:       []string{"5xx"},
+		LogRequestHeaders: []string{"User-Agent"},
+	}
+	handler, err := New(ctx, next, config, "trace-id-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+
+	for _, status = range []int{http.StatusOK, http.StatusServiceUnavailable} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+		if err != nil {
+			t.Fatalf("error with request: %+v", err)
+		}
+		req.Header.Set("User-Agent", "test-agent")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("error reading log output: %+v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected only the 503 to be logged, got %q", data)
+	}
+	var line struct {
+		Status  int               `json:"status"`
+		TraceID string            `json:"traceId"`
+		Headers map[string]string `json:"headers"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatalf("log output is not JSON: %q", lines[0])
+	}
+	if line.Status != http.StatusServiceUnavailable || line.TraceID == "" || line.Headers["User-Agent"] != "test-agent" {
+		t.Fatalf("unexpected problem log line %q", lines[0])
+	}
+}
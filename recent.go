@@ -0,0 +1,118 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var defaultLookupNetworks = []string{"127.0.0.1/32", "::1/128"}
+
+// requestSummary is what the lookup endpoint tells about one request.
+type requestSummary struct {
+	TraceID       string  `json:"traceId"`
+	Source        string  `json:"source"`
+	SpanID        string  `json:"spanId,omitempty"`
+	ParentSpanID  string  `json:"parentSpanId,omitempty"`
+	CorrelationID string  `json:"correlationId,omitempty"`
+	Time          string  `json:"time"`
+	Method        string  `json:"method"`
+	Host          string  `json:"host"`
+	Path          string  `json:"path"`
+	RemoteAddr    string  `json:"remoteAddr"`
+	UserAgent     string  `json:"userAgent,omitempty"`
+	Status        int     `json:"status"`
+	Bytes         int64   `json:"bytes"`
+	DurationMs    float64 `json:"durationMs"`
+}
+
+type recentSlot struct {
+	mu      sync.Mutex
+	summary requestSummary
+	used    bool
+}
+
+// recentRequests is a fixed-size ring of the last requests. Writers only
+// contend on an atomic counter and the one slot they overwrite, lookups
+// (which are rare) walk the whole ring.
+type recentRequests struct {
+	slots []recentSlot
+	next  uint64
+}
+
+func newRecentRequests(size int) *recentRequests {
+	return &recentRequests{slots: make([]recentSlot, size)}
+}
+
+func (r *recentRequests) add(summary requestSummary) {
+	i := (atomic.AddUint64(&r.next, 1) - 1) % uint64(len(r.slots))
+	slot := &r.slots[i]
+	slot.mu.Lock()
+	slot.summary = summary
+	slot.used = true
+	slot.mu.Unlock()
+}
+
+// find returns all remembered requests with this trace ID, oldest first.
+func (r *recentRequests) find(traceValue string) []requestSummary {
+	var found []requestSummary
+	next := atomic.LoadUint64(&r.next)
+	size := uint64(len(r.slots))
+	for n := uint64(0); n < size; n++ {
+		slot := &r.slots[(next+n)%size]
+		slot.mu.Lock()
+		if slot.used && slot.summary.TraceID == traceValue {
+			found = append(found, slot.summary)
+		}
+		slot.mu.Unlock()
+	}
+	return found
+}
+
+func (t *TraceIDHeader) newRequestSummary(req *http.Request, rw *responseWriter, traceID TraceID, start, end time.Time) requestSummary {
+	return requestSummary{
+		TraceID:       traceID.Value,
+		Source:        traceID.Source,
+		SpanID:        traceID.SpanID,
+		ParentSpanID:  traceID.ParentSpanID,
+		CorrelationID: traceID.CorrelationID,
+		Time:          start.UTC().Format(time.RFC3339Nano),
+		Method:        req.Method,
+		Host:          req.Host,
+		Path:          req.URL.Path,
+		RemoteAddr:    req.RemoteAddr,
+		UserAgent:     req.UserAgent(),
+		Status:        rw.status,
+		Bytes:         rw.bytes,
+		DurationMs:    durationMs(end.Sub(start)),
+	}
+}
+
+// isLookupRequest is a GET for lookupPath/<trace id> from an allowed address,
+// anyone else just gets passed upstream like any other request.
+func (t *TraceIDHeader) isLookupRequest(req *http.Request) bool {
+	return t.recent != nil && t.lookupPath != "" &&
+		strings.HasPrefix(req.URL.Path, t.lookupPath) &&
+		(req.Method == http.MethodGet || req.Method == http.MethodHead) &&
+		remoteAddrInNetworks(req.RemoteAddr, t.lookupNetworks)
+}
+
+func (t *TraceIDHeader) serveLookup(rw http.ResponseWriter, req *http.Request) {
+	traceValue := strings.TrimPrefix(req.URL.Path, t.lookupPath)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+
+	found := t.recent.find(traceValue)
+	if len(found) == 0 {
+		rw.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(rw).Encode(map[string]string{"traceId": traceValue, "error": "not found"})
+		return
+	}
+	_ = json.NewEncoder(rw).Encode(struct {
+		TraceID  string           `json:"traceId"`
+		Requests []requestSummary `json:"requests"`
+	}{TraceID: traceValue, Requests: found})
+}
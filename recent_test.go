@@ -0,0 +1,80 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestRecentRequestsRing(t *testing.T) {
+	r := newRecentRequests(3)
+	for i := 0; i < 5; i++ {
+		r.add(requestSummary{TraceID: "id-" + strconv.Itoa(i%4), Status: i})
+	}
+
+	if found := r.find("id-1"); len(found) != 0 {
+		t.Fatalf("expected id-1 to be pushed out of the ring, got %+v", found)
+	}
+	found := r.find("id-0")
+	if len(found) != 1 || found[0].Status != 4 {
+		t.Fatalf("expected only the newest id-0, got %+v", found)
+	}
+}
+
+func TestLookupEndpoint(t *testing.T) {
+	ctx := context.Background()
+
+	var reqValue string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/orders" {
+			reqValue = req.Header.Get("X-Trace-Id")
+		}
+		rw.WriteHeader(http.StatusTeapot)
+	})
+	config := &Config{RecentRequests: 10, LookupPath: "/_trace", LookupNetworks: []string{"10.0.0.0/8"}}
+	handler, err := New(ctx, next, config, "trace-id-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+	serve := func(path, remoteAddr string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost"+path, nil)
+		if err != nil {
+			t.Fatalf("error with request: %+v", err)
+		}
+		req.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Result()
+	}
+
+	serve("/orders", "192.0.2.1:1234")
+
+	resp := serve("/_trace/"+reqValue, "10.1.2.3:1234")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the lookup to succeed, got %d", resp.StatusCode)
+	}
+	var result struct {
+		TraceID  string           `json:"traceId"`
+		Requests []requestSummary `json:"requests"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("invalid lookup response: %v", err)
+	}
+	if result.TraceID != reqValue || len(result.Requests) != 1 {
+		t.Fatalf("unexpected lookup response %+v", result)
+	}
+	if got := result.Requests[0]; got.Path != "/orders" || got.Status != http.StatusTeapot || got.RemoteAddr != "192.0.2.1:1234" {
+		t.Fatalf("unexpected request summary %+v", got)
+	}
+
+	if resp := serve("/_trace/unknown", "10.1.2.3:1234"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown trace ID, got %d", resp.StatusCode)
+	}
+	if resp := serve("/_trace/"+reqValue, "192.0.2.1:1234"); resp.StatusCode != http.StatusTeapot {
+		t.Fatalf("expected lookups from outside lookupNetworks to go upstream, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,97 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cdwiegand/traefik-add-trace-id-header-2/uuid"
+)
+
+// serveNext calls next, recovering from its panics when recoverPanics is set.
+func (t *TraceIDHeader) serveNext(rw *responseWriter, req *http.Request, traceValue string) {
+	if t.recoverPanics {
+		defer t.recoverPanic(rw, req, traceValue)
+	}
+	t.next.ServeHTTP(rw, req)
+}
+
+// recoverPanic logs the panic of next with its stack and, if nothing was sent
+// yet, answers with a 500 carrying the trace ID in a header and a small body.
+func (t *TraceIDHeader) recoverPanic(rw *responseWriter, req *http.Request, traceValue string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if r == http.ErrAbortHandler {
+		panic(r) // deliberate abort, not ours to swallow
+	}
+
+	attrs := append(requestLogAttrs(req, traceValue), slog.String("panic", fmt.Sprint(r)), slog.String("stack", string(debug.Stack())))
+	t.logger.Error("recovered from panic", attrs...)
+
+	if rw.wroteHeader && rw.rewrite == nil {
+		// upstream's status and headers already went out, all we can do is cut the response short
+		panic(http.ErrAbortHandler)
+	}
+
+	if !rw.wroteHeader {
+		// same header hook as any other response, so the 500 still gets the
+		// exposed CORS headers, the correlation cookie, Server-Timing etc.
+		rw.wroteHeader = true
+		rw.status = http.StatusInternalServerError
+		rw.wroteHeaderAt = time.Now()
+		if rw.onWriteHeader != nil {
+			rw.onWriteHeader(rw, http.StatusInternalServerError)
+		}
+	}
+	rw.rewrite = nil
+	rw.buffer.Reset()
+	hdr := rw.Header()
+	hdr.Del("Content-Length")
+	hdr.Del("Content-Encoding")
+	hdr.Set(t.responseHeaderName, traceValue)
+
+	var body []byte
+	if strings.Contains(req.Header.Get("Accept"), "json") {
+		hdr.Set("Content-Type", "application/json")
+		body, _ = json.Marshal(map[string]string{"error": http.StatusText(http.StatusInternalServerError), t.errorBodyField: traceValue})
+	} else {
+		hdr.Set("Content-Type", "text/plain; charset=utf-8")
+		body = []byte(http.StatusText(http.StatusInternalServerError) + "\nTrace ID: " + traceValue + "\n")
+	}
+	hdr.Set("Content-Length", strconv.Itoa(len(body)))
+
+	rw.status = http.StatusInternalServerError
+	rw.ResponseWriter.WriteHeader(http.StatusInternalServerError)
+	n, _ := rw.ResponseWriter.Write(body)
+	rw.bytes += int64(n)
+}
+
+// generateTraceIDSafely falls back to a plain UUIDv4 if the configured generator panics.
+func (t *TraceIDHeader) generateTraceIDSafely(now time.Time) (traceID TraceID) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.countGeneratorFailure()
+			t.logger.Error("trace id generator panicked", slog.String("panic", fmt.Sprint(r)), slog.String("stack", string(debug.Stack())))
+			traceID = t.fallbackTraceID()
+		}
+	}()
+	return t.generateTraceID(now)
+}
+
+// fallbackTraceID is a plain UUIDv4 for when the configured generator fails.
+// If that fails too, the value is left empty: every failed request would
+// otherwise share the nil UUID.
+func (t *TraceIDHeader) fallbackTraceID() TraceID {
+	fallback, err := uuid.NewV4()
+	if err != nil {
+		return TraceID{Source: TraceIDSourceGenerated, Format: TraceIDFormatUnknown}
+	}
+	return TraceID{Value: t.valuePrefix + fallback.String(), Source: TraceIDSourceGenerated, Format: TraceIDFormatUUID, UUID: fallback}
+}
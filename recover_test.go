@@ -0,0 +1,253 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdwiegand/traefik-add-trace-id-header-2/uuid"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		body        func(traceValue string) string
+	}{
+		{
+			name:        "plain text",
+			contentType: "text/plain; charset=utf-8",
+			body: func(traceValue string) string {
+				return "Internal Server Error\nTrace ID: " + traceValue + "\n"
+			},
+		},
+		{
+			name:        "json",
+			accept:      "application/json",
+			contentType: "application/json",
+			body: func(traceValue string) string {
+				return `{"error":"Internal Server Error","traceId":"` + traceValue + `"}`
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			logFile := filepath.Join(t.TempDir(), "trace.log")
+
+			var traceValue string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				traceValue = req.Header.Get(defaultHeaderName)
+				rw.Header().Set("Content-Type", "image/png")
+				panic("boom")
+			})
+			config := &Config{RecoverPanics: true, AddToResponse: false, LogOutput: logFile}
+			handler, err := New(ctx, next, config, "trace-id-test")
+			if err != nil {
+				t.Fatalf("error creating new plugin instance: %+v", err)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+			if err != nil {
+				t.Fatalf("error with request: %+v", err)
+			}
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			recorder := httptest.NewRecorder()
+
+			handler.ServeHTTP(recorder, req)
+
+			resp := recorder.Result()
+			if resp.StatusCode != http.StatusInternalServerError {
+				t.Fatalf("wanted status 500, got %d", resp.StatusCode)
+			}
+			mustHaveValues(t, resp.Header.Values(defaultHeaderName), traceValue)
+			if resp.Header.Get("Content-Type") != tt.contentType {
+				t.Fatalf("wanted content type %q, got %q", tt.contentType, resp.Header.Get("Content-Type"))
+			}
+			if want := tt.body(traceValue); recorder.Body.String() != want {
+				t.Fatalf("wanted body %q, got %q", want, recorder.Body.String())
+			}
+
+			data, err := os.ReadFile(logFile)
+			if err != nil {
+				t.Fatalf("error reading log output: %+v", err)
+			}
+			if !strings.Contains(string(data), "recovered from panic") || !strings.Contains(string(data), traceValue) {
+				t.Fatalf("panic was not logged with the trace ID: %q", data)
+			}
+		})
+	}
+}
+
+func TestRecoverPanicsAfterHeadersAborts(t *testing.T) {
+	ctx := context.Background()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		panic("boom")
+	})
+	config := &Config{RecoverPanics: true, LogOutput: filepath.Join(t.TempDir(), "trace.log")}
+	handler, err := New(ctx, next, config, "trace-id-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Fatalf("wanted http.ErrAbortHandler, got %v", r)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestRecoverPanicsRunsHeaderHook(t *testing.T) {
+	ctx := context.Background()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		panic("boom")
+	})
+	config := &Config{
+		RecoverPanics: true,
+		AddToResponse: true,
+		ExposeHeaders: true,
+		Correlation:   true,
+		ServerTiming:  true,
+		LogOutput:     filepath.Join(t.TempDir(), "trace.log"),
+	}
+	handler, err := New(ctx, next, config, "trace-id-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, req)
+	resp := recorder.Result()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Access-Control-Expose-Headers"); !strings.Contains(got, defaultHeaderName) {
+		t.Fatalf("expected the trace header to be exposed, got %q", got)
+	}
+	if got := resp.Header.Get("Set-Cookie"); !strings.HasPrefix(got, defaultCorrelationCookieName+"=") {
+		t.Fatalf("expected the correlation cookie, got %q", got)
+	}
+	if got := resp.Header.Get("Server-Timing"); !strings.HasPrefix(got, "traefik;dur=") {
+		t.Fatalf("expected a Server-Timing entry, got %q", got)
+	}
+}
+
+func TestGenerateTraceIDSafely(t *testing.T) {
+	testMe := &TraceIDHeader{
+		uuidGen:       "L",
+		recoverPanics: true,
+		name:          "generator-test",
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		metrics:       newMetricsRegistry(),
+	}
+
+	// beyond what a ULID's 48 bit timestamp can hold, ulid.MustNew panics
+	traceID := testMe.newTraceID(time.Date(20000, 1, 1, 0, 0, 0, 0, time.UTC))
+	if traceID.Format != TraceIDFormatUUID || traceID.UUID.Version() != 4 || traceID.Source != TraceIDSourceGenerated {
+		t.Fatalf("expected a UUIDv4 fallback, got %+v", traceID)
+	}
+	mustHaveLength(t, traceID.Value, 36)
+
+	var out strings.Builder
+	testMe.metrics.writeTo(&out)
+	want := `traceid_generator_failures_total{middleware="generator-test",generator="L"} 1`
+	if !strings.Contains(out.String(), want) {
+		t.Fatalf("metrics are missing %q:\n%s", want, out.String())
+	}
+}
+
+// failingReader fails the first failures reads, then reads zeroes.
+type failingReader struct {
+	failures int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.failures != 0 {
+		r.failures--
+		return 0, io.ErrUnexpectedEOF
+	}
+	clear(p)
+	return len(p), nil
+}
+
+func withRandomReader(t *testing.T, reader io.Reader) {
+	t.Helper()
+	saved := uuid.DefaultGenerator
+	uuid.DefaultGenerator = uuid.NewGenWithOptions(uuid.WithRandomReader(reader))
+	t.Cleanup(func() { uuid.DefaultGenerator = saved })
+}
+
+func TestGenerateTraceIDFallback(t *testing.T) {
+	testMe := &TraceIDHeader{
+		uuidGen: "7",
+		name:    "generator-test",
+		metrics: newMetricsRegistry(),
+	}
+
+	withRandomReader(t, &failingReader{failures: 1})
+	traceID := testMe.generateTraceID(time.Now())
+	if traceID.Format != TraceIDFormatUUID || traceID.UUID.Version() != 4 || traceID.Value == uuid.Nil.String() {
+		t.Fatalf("expected a UUIDv4 fallback, got %+v", traceID)
+	}
+
+	withRandomReader(t, &failingReader{failures: -1})
+	traceID = testMe.generateTraceID(time.Now())
+	if traceID.Value != "" {
+		t.Fatalf("expected no trace ID when the fallback fails too, got %+v", traceID)
+	}
+
+	var out strings.Builder
+	testMe.metrics.writeTo(&out)
+	want := `traceid_generator_failures_total{middleware="generator-test",generator="7"} 2`
+	if !strings.Contains(out.String(), want) {
+		t.Fatalf("metrics are missing %q:\n%s", want, out.String())
+	}
+}
+
+func TestServeHTTPWithoutTraceID(t *testing.T) {
+	ctx := context.Background()
+	withRandomReader(t, &failingReader{failures: -1})
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mustHaveValues(t, req.Header.Values(defaultHeaderName))
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	handler, err := New(ctx, next, &Config{UuidGen: "4", AddToResponse: true}, "trace-id-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+	req.Header.Set(defaultHeaderName, "spoofed")
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, req)
+	resp := recorder.Result()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("wanted status 204, got %d", resp.StatusCode)
+	}
+	mustHaveValues(t, resp.Header.Values(defaultHeaderName))
+}
@@ -0,0 +1,140 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxBufferedBody is how much of a response bufferBody holds back, larger
+// bodies are sent on unchanged
+const maxBufferedBody = 64 << 10
+
+// responseWriter wraps the http.ResponseWriter handed to next, so we can look at
+// (and amend) the upstream response headers right before they are sent, and
+// know the status and body size afterwards.
+type responseWriter struct {
+	http.ResponseWriter
+	wroteHeader   bool
+	status        int
+	wroteHeaderAt time.Time
+	bytes         int64
+	onWriteHeader func(rw *responseWriter, code int)
+	rewrite       func(body []byte) []byte
+	buffer        bytes.Buffer
+}
+
+func newResponseWriter(rw http.ResponseWriter, onWriteHeader func(rw *responseWriter, code int)) *responseWriter {
+	return &responseWriter{
+		ResponseWriter: rw,
+		onWriteHeader:  onWriteHeader,
+	}
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		// informational responses (103 Early Hints etc.) can be sent more than once
+		rw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	rw.wroteHeader = true
+	rw.status = code
+	rw.wroteHeaderAt = time.Now()
+	if rw.onWriteHeader != nil {
+		rw.onWriteHeader(rw, code)
+	}
+	if rw.rewrite != nil {
+		return // sent by finish, together with the rewritten body
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// bufferBody holds the response back until finish, where rewrite gets to
+// replace the body, as long as it stays within maxBufferedBody. Only meant to
+// be called from onWriteHeader.
+func (rw *responseWriter) bufferBody(rewrite func(body []byte) []byte) {
+	rw.rewrite = rewrite
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.rewrite != nil {
+		if rw.buffer.Len()+len(b) <= maxBufferedBody {
+			return rw.buffer.Write(b)
+		}
+		if err := rw.stopBuffering(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// finish makes sure our hook ran even if next never wrote anything at all,
+// and sends a buffered response.
+func (rw *responseWriter) finish() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.rewrite == nil {
+		return
+	}
+
+	body := rw.rewrite(rw.buffer.Bytes())
+	rw.rewrite = nil
+	rw.buffer.Reset()
+	rw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	rw.ResponseWriter.WriteHeader(rw.status)
+	n, _ := rw.ResponseWriter.Write(body)
+	rw.bytes += int64(n)
+}
+
+// stopBuffering gives up on rewriting a body that grew too large: the headers
+// and what was buffered so far are sent as upstream wrote them.
+func (rw *responseWriter) stopBuffering() error {
+	rw.rewrite = nil
+	rw.ResponseWriter.WriteHeader(rw.status)
+	n, err := rw.ResponseWriter.Write(rw.buffer.Bytes())
+	rw.bytes += int64(n)
+	rw.buffer.Reset()
+	return err
+}
+
+func (rw *responseWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.rewrite != nil {
+		return // nothing to flush until finish
+	}
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T is not a http.Hijacker", rw.ResponseWriter)
+	}
+	rw.wroteHeader = true // connection is no longer ours to write to
+	if rw.status == 0 {
+		rw.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the original writer.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
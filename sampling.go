@@ -0,0 +1,147 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	samplerAlways = "always"
+	samplerNever  = "never"
+	samplerRatio  = "ratio"
+	samplerParent = "parent" // follow a trusted caller's decision, ratio otherwise
+
+	samplingPropagationTraceparent = "traceparent"
+	samplingPropagationB3          = "b3"
+	samplingPropagationHeader      = "header"
+
+	defaultSampledHeaderName = "X-Trace-Sampled"
+)
+
+func validateSampling(config *Config) error {
+	switch strings.ToLower(config.Sampler) {
+	case "", samplerAlways, samplerNever, samplerRatio, samplerParent:
+	default:
+		return fmt.Errorf("only sampler of always, never, ratio, or parent is supported")
+	}
+	if config.SampleRatio < 0 || config.SampleRatio > 1 {
+		return fmt.Errorf("sample ratio must be between 0 and 1")
+	}
+	for _, p := range config.SamplingPropagation {
+		switch strings.ToLower(p) {
+		case samplingPropagationTraceparent, samplingPropagationB3, samplingPropagationHeader:
+		default:
+			return fmt.Errorf("only sampling propagation of traceparent, b3, or header is supported")
+		}
+	}
+	return nil
+}
+
+// sampleRandomBits returns 64 bits of the trace ID that are random (the low
+// half of a UUID, like OpenTelemetry's ratio sampler uses), a mix of the ULID
+// entropy, or a hash of values we could not parse, so the same ID always gets
+// the same decision.
+func sampleRandomBits(traceID TraceID) uint64 {
+	switch traceID.Format {
+	case TraceIDFormatUUID:
+		// the top 2 bits of the low half are the UUID variant, shift them out
+		return binary.BigEndian.Uint64(traceID.UUID[8:16]) << 2
+	case TraceIDFormatULID:
+		// monotonic ULIDs of the same millisecond only differ in their lowest
+		// bits, mix all 80 bits of entropy so each of them gets its own decision
+		x := binary.BigEndian.Uint64(traceID.ULID[8:16]) ^ uint64(binary.BigEndian.Uint16(traceID.ULID[6:8]))<<48
+		return splitmix64(x)
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(traceID.Value))
+	return h.Sum64()
+}
+
+// splitmix64 is the finalizer of the SplitMix64 generator, a cheap 64 bit mixer
+// where every input bit affects every output bit.
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// sampleByRatio keeps roughly sampleRatio of all trace IDs, deterministically.
+func (t *TraceIDHeader) sampleByRatio(traceID TraceID) bool {
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	bound := uint64(t.sampleRatio * (1 << 63))
+	return sampleRandomBits(traceID)>>1 < bound
+}
+
+// parentSampled reads the sampling decision a caller sent us, from any of the
+// formats we know, in the order traceparent, B3, plain header.
+func (t *TraceIDHeader) parentSampled(req *http.Request) (sampled bool, found bool) {
+	if tp := req.Header.Get("traceparent"); len(tp) == 55 && tp[2] == '-' && tp[35] == '-' && tp[52] == '-' {
+		if flags, err := strconv.ParseUint(tp[53:], 16, 8); err == nil {
+			return flags&0x01 == 0x01, true
+		}
+	}
+	if b3 := req.Header.Get("X-B3-Sampled"); b3 != "" {
+		return b3 == "1" || strings.EqualFold(b3, "true"), true
+	}
+	if b3 := req.Header.Get("b3"); b3 != "" {
+		parts := strings.Split(b3, "-")
+		decision := parts[0]
+		if len(parts) >= 3 {
+			decision = parts[2]
+		}
+		switch decision {
+		case "1", "d":
+			return true, true
+		case "0":
+			return false, true
+		}
+	}
+	if v := req.Header.Get(t.sampledHeaderName); v != "" {
+		return v == "1" || strings.EqualFold(v, "true"), true
+	}
+	return false, false
+}
+
+// sample makes the head-based sampling decision for this request.
+func (t *TraceIDHeader) sample(req *http.Request, traceID TraceID) bool {
+	switch t.sampler {
+	case samplerAlways:
+		return true
+	case samplerNever:
+		return false
+	case samplerParent:
+		if t.isTrusted(req) {
+			if sampled, found := t.parentSampled(req); found {
+				return sampled
+			}
+		}
+	}
+	return t.sampleByRatio(traceID)
+}
+
+// propagateSampling forwards the sampling decision upstream in the configured formats.
+func (t *TraceIDHeader) propagateSampling(req *http.Request, traceID TraceID) {
+	flag := "0"
+	if traceID.Sampled {
+		flag = "1"
+	}
+	for _, p := range t.samplingPropagation {
+		switch p {
+		case samplingPropagationHeader:
+			req.Header.Set(t.sampledHeaderName, flag)
+		case samplingPropagationB3:
+			req.Header.Set("X-B3-Sampled", flag)
+		case samplingPropagationTraceparent:
+			if traceHex := traceID.Hex(); traceHex != "" {
+				req.Header.Set("traceparent", "00-"+traceHex+"-"+traceID.SpanID+"-0"+flag)
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestSampleByRatioIsDeterministic(t *testing.T) {
+	testMe := &TraceIDHeader{uuidGen: "4", sampleRatio: 0.25}
+
+	kept := 0
+	for i := 0; i < 4000; i++ {
+		traceID := testMe.generateTraceID(time.Now())
+		sampled := testMe.sampleByRatio(traceID)
+		if again := testMe.sampleByRatio(testMe.parseTraceValue(traceID.Value, TraceIDSourcePropagated)); again != sampled {
+			t.Fatalf("decision for %s changed after propagation", traceID.Value)
+		}
+		if sampled {
+			kept++
+		}
+	}
+	if kept < 800 || kept > 1200 {
+		t.Fatalf("wanted roughly 1000 of 4000 traces sampled at 25%%, got %d", kept)
+	}
+}
+
+func TestSampleByRatioULIDsOfOneMillisecond(t *testing.T) {
+	testMe := &TraceIDHeader{uuidGen: "L", sampleRatio: 0.5}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	kept := 0
+	for i := 0; i < 4000; i++ {
+		// monotonic entropy: every ID is the previous one plus a small increment
+		traceID := testMe.generateTraceID(now)
+		sampled := testMe.sampleByRatio(traceID)
+		if again := testMe.sampleByRatio(testMe.parseTraceValue(traceID.Value, TraceIDSourcePropagated)); again != sampled {
+			t.Fatalf("decision for %s changed after propagation", traceID.Value)
+		}
+		if sampled {
+			kept++
+		}
+	}
+	if kept < 1800 || kept > 2200 {
+		t.Fatalf("wanted roughly 2000 of 4000 ULIDs of the same millisecond sampled at 50%%, got %d", kept)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *Config
+		headers     map[string]string
+		wantSampled bool
+		wantHeaders map[string]string
+	}{
+		{
+			name:        "always",
+			config:      &Config{Sampler: "always", SamplingPropagation: []string{"header", "b3"}},
+			wantSampled: true,
+			wantHeaders: map[string]string{"X-Trace-Sampled": "1", "X-B3-Sampled": "1"},
+		},
+		{
+			name:        "never",
+			config:      &Config{Sampler: "never"},
+			wantHeaders: map[string]string{"X-Trace-Sampled": "0"},
+		},
+		{
+			name:        "parent traceparent from trusted caller",
+			config:      &Config{Sampler: "parent", SampleRatio: 0, TrustAllIPs: true},
+			headers:     map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			wantSampled: true,
+			wantHeaders: map[string]string{"X-Trace-Sampled": "1"},
+		},
+		{
+			name:        "parent b3 from trusted caller",
+			config:      &Config{Sampler: "parent", SampleRatio: 1, TrustAllIPs: true},
+			headers:     map[string]string{"b3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-0"},
+			wantHeaders: map[string]string{"X-Trace-Sampled": "0"},
+		},
+		{
+			name:        "parent from untrusted caller falls back to ratio",
+			config:      &Config{Sampler: "parent", SampleRatio: 0},
+			headers:     map[string]string{"X-Trace-Sampled": "1"},
+			wantHeaders: map[string]string{"X-Trace-Sampled": "0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var got TraceID
+			var upstream http.Header
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got, _ = TraceIDFromContext(req.Context())
+				upstream = req.Header
+			})
+			handler, err := New(ctx, next, tt.config, "trace-id-test")
+			if err != nil {
+				t.Fatalf("error creating new plugin instance: %+v", err)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+			if err != nil {
+				t.Fatalf("error with request: %+v", err)
+			}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if got.Sampled != tt.wantSampled {
+				t.Fatalf("wanted sampled %v, got %v", tt.wantSampled, got.Sampled)
+			}
+			for k, v := range tt.wantHeaders {
+				if upstream.Get(k) != v {
+					t.Fatalf("wanted upstream %s: %q, got %q", k, v, upstream.Get(k))
+				}
+			}
+		})
+	}
+}
+
+func TestSamplingTraceparentPropagation(t *testing.T) {
+	ctx := context.Background()
+
+	var got TraceID
+	var traceparent string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		got, _ = TraceIDFromContext(req.Context())
+		traceparent = req.Header.Get("traceparent")
+	})
+	config := &Config{Sampler: "always", SamplingPropagation: []string{"traceparent"}, SpanIds: true, UuidGen: "7"}
+	handler, err := New(ctx, next, config, "trace-id-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	want := "00-" + got.Hex() + "-" + got.SpanID + "-01"
+	if traceparent != want || !regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`).MatchString(traceparent) {
+		t.Fatalf("wanted traceparent %q, got %q", want, traceparent)
+	}
+}
+
+func TestNewRejectsInvalidSampling(t *testing.T) {
+	for _, config := range []*Config{{Sampler: "sometimes"}, {SampleRatio: 1.5}, {SamplingPropagation: []string{"jaeger"}}} {
+		if _, err := New(context.Background(), http.NotFoundHandler(), config, "trace-id-test"); err == nil {
+			t.Fatalf("expected an error for %+v", config)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// tracing headers by propagator, stripped from requests of untrusted clients
+var propagatorHeaders = map[string][]string{
+	"tracecontext": {"traceparent", "tracestate"},
+	"baggage":      {"baggage"},
+	"b3":           {"b3", "X-B3-TraceId", "X-B3-SpanId", "X-B3-ParentSpanId", "X-B3-Sampled", "X-B3-Flags"},
+	"jaeger":       {"uber-trace-id", "jaeger-debug-id", "jaeger-baggage"},
+	"xray":         {"X-Amzn-Trace-Id"},
+	"datadog":      {"x-datadog-trace-id", "x-datadog-parent-id", "x-datadog-sampling-priority", "x-datadog-origin", "x-datadog-tags"},
+	"cloudtrace":   {"X-Cloud-Trace-Context", "grpc-trace-bin"},
+	"ot":           {"ot-tracer-traceid", "ot-tracer-spanid", "ot-tracer-sampled"},
+	"requestid":    {"X-Request-Id", "Request-Id", "X-Correlation-Id"},
+}
+
+// baggage and request IDs are often set by browsers and apps on purpose, so
+// they're only stripped when asked for
+var defaultStripPropagators = []string{"tracecontext", "b3", "jaeger", "xray", "datadog", "cloudtrace", "ot"}
+
+// parseStripHeaders expands the propagator names to the header names to strip.
+func parseStripHeaders(propagators, extra []string) ([]string, error) {
+	if len(propagators) == 0 {
+		propagators = defaultStripPropagators
+	}
+	var headers []string
+	for _, p := range propagators {
+		names, ok := propagatorHeaders[strings.ToLower(strings.TrimSpace(p))]
+		if !ok {
+			known := make([]string, 0, len(propagatorHeaders))
+			for name := range propagatorHeaders {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("only strip propagators of %s, or %s are supported", strings.Join(known[:len(known)-1], ", "), known[len(known)-1])
+		}
+		headers = append(headers, names...)
+	}
+	for _, h := range extra {
+		if h = strings.TrimSpace(h); h != "" {
+			headers = append(headers, h)
+		}
+	}
+	return headers, nil
+}
+
+// stripUntrustedHeaders removes tracing headers an untrusted client may have
+// made up, before we set our own, so they never reach upstream.
+func (t *TraceIDHeader) stripUntrustedHeaders(req *http.Request) {
+	if t.isTrusted(req) {
+		return
+	}
+	var stripped []string
+	for _, h := range t.stripHeaders {
+		if _, ok := req.Header[http.CanonicalHeaderKey(h)]; ok {
+			req.Header.Del(h)
+			stripped = append(stripped, h)
+		}
+	}
+	if len(stripped) > 0 {
+		t.logger.Debug("stripped tracing headers from untrusted client", slog.String("remoteAddr", req.RemoteAddr), slog.Any("headers", stripped))
+	}
+}
@@ -0,0 +1,106 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStripUntrustedHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     *Config
+		remoteAddr string
+		stripped   []string
+		kept       []string
+	}{
+		{
+			name:       "defaults for untrusted client",
+			config:     &Config{TrustNetworks: []string{"10.0.0.0/8"}},
+			remoteAddr: "192.0.2.1:1234",
+			stripped:   []string{"traceparent", "tracestate", "X-B3-TraceId", "b3", "X-Amzn-Trace-Id", "uber-trace-id", "x-datadog-trace-id", "X-Cloud-Trace-Context"},
+			kept:       []string{"baggage", "X-Request-Id", "X-Debug-Trace"},
+		},
+		{
+			name:       "trusted client keeps everything",
+			config:     &Config{TrustNetworks: []string{"10.0.0.0/8"}},
+			remoteAddr: "10.1.2.3:1234",
+			kept:       []string{"traceparent", "b3", "X-Amzn-Trace-Id", "baggage"},
+		},
+		{
+			name:       "selected propagators and extra headers",
+			config:     &Config{StripPropagators: []string{"Baggage", "xray"}, StripHeaders: []string{"x-debug-trace"}},
+			remoteAddr: "192.0.2.1:1234",
+			stripped:   []string{"baggage", "X-Amzn-Trace-Id", "X-Debug-Trace"},
+			kept:       []string{"traceparent", "b3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			tt.config.StripUntrusted = true
+
+			var got http.Header
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got = req.Header.Clone()
+			})
+			handler, err := New(ctx, next, tt.config, "trace-id-test")
+			if err != nil {
+				t.Fatalf("error creating new plugin instance: %+v", err)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+			if err != nil {
+				t.Fatalf("error with request: %+v", err)
+			}
+			req.RemoteAddr = tt.remoteAddr
+			for _, h := range append(append([]string{}, tt.stripped...), tt.kept...) {
+				req.Header.Set(h, "from-client")
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			for _, h := range tt.stripped {
+				if got.Get(h) != "" {
+					t.Fatalf("expected %s to be stripped, got %q", h, got.Get(h))
+				}
+			}
+			for _, h := range tt.kept {
+				if got.Get(h) != "from-client" {
+					t.Fatalf("expected %s to be kept, got %q", h, got.Get(h))
+				}
+			}
+		})
+	}
+}
+
+func TestStripUntrustedKeepsOwnPropagation(t *testing.T) {
+	ctx := context.Background()
+
+	var traceparent string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		traceparent = req.Header.Get("traceparent")
+	})
+	config := &Config{StripUntrusted: true, Sampler: "always", SamplingPropagation: []string{"traceparent"}}
+	handler, err := New(ctx, next, config, "trace-id-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+	spoofed := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req.Header.Set("traceparent", spoofed)
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if traceparent == "" || traceparent == spoofed {
+		t.Fatalf("expected our own traceparent upstream, got %q", traceparent)
+	}
+}
+
+func TestNewRejectsUnknownStripPropagator(t *testing.T) {
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{StripPropagators: []string{"zipkin"}}, "trace-id-test")
+	if err == nil {
+		t.Fatal("expected an error for an unknown propagator")
+	}
+}
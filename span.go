@@ -0,0 +1,66 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultSpanHeaderName       = "X-Span-Id"
+	defaultParentSpanHeaderName = "X-Parent-Span-Id"
+)
+
+// newSpanID returns a random, non-zero 64-bit span ID as 16 lowercase hex characters.
+func newSpanID() string {
+	var b [8]byte
+	for {
+		_, _ = rand.Read(b[:])
+		if b != [8]byte{} {
+			return hex.EncodeToString(b[:])
+		}
+	}
+}
+
+// isValidSpanID accepts 16 hex characters that are not all zero.
+func isValidSpanID(value string) bool {
+	if len(value) != 16 || value == "0000000000000000" {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+// needsSpanID reports whether something identifies this hop by span ID even
+// with spanIds off. traceparent, traceresponse and the exported span have to
+// agree on one, or upstream spans point at a parent that was never exported.
+func (t *TraceIDHeader) needsSpanID() bool {
+	if t.traceResponse || len(t.spanExporters) > 0 {
+		return true
+	}
+	if t.sampler != "" {
+		for _, p := range t.samplingPropagation {
+			if p == samplingPropagationTraceparent {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// assignSpan gives this hop its own span ID, with the previous hop's span (as
+// sent in the span header by a trusted client) as its parent, and forwards both.
+func (t *TraceIDHeader) assignSpan(req *http.Request, traceID *TraceID) {
+	traceID.SpanID = newSpanID()
+	if incoming := strings.ToLower(req.Header.Get(t.spanHeaderName)); isValidSpanID(incoming) && t.isTrusted(req) {
+		traceID.ParentSpanID = incoming
+	}
+
+	req.Header.Set(t.spanHeaderName, traceID.SpanID)
+	if traceID.ParentSpanID != "" {
+		req.Header.Set(t.parentSpanHeaderName, traceID.ParentSpanID)
+	} else {
+		req.Header.Del(t.parentSpanHeaderName)
+	}
+}
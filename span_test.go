@@ -0,0 +1,76 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSpanIds(t *testing.T) {
+	const parent = "00f067aa0ba902b7"
+
+	tests := []struct {
+		name       string
+		config     *Config
+		incoming   string
+		wantParent string
+	}{
+		{
+			name:   "no previous hop",
+			config: &Config{SpanIds: true},
+		},
+		{
+			name:       "trusted previous hop",
+			config:     &Config{SpanIds: true, TrustAllIPs: true},
+			incoming:   parent,
+			wantParent: parent,
+		},
+		{
+			name:     "untrusted previous hop is ignored",
+			config:   &Config{SpanIds: true},
+			incoming: parent,
+		},
+		{
+			name:     "invalid span id is ignored",
+			config:   &Config{SpanIds: true, TrustAllIPs: true},
+			incoming: "0000000000000000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var got TraceID
+			var spanHeader, parentHeader string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got, _ = TraceIDFromContext(req.Context())
+				spanHeader = req.Header.Get("X-Span-Id")
+				parentHeader = req.Header.Get("X-Parent-Span-Id")
+			})
+			handler, err := New(ctx, next, tt.config, "trace-id-test")
+			if err != nil {
+				t.Fatalf("error creating new plugin instance: %+v", err)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+			if err != nil {
+				t.Fatalf("error with request: %+v", err)
+			}
+			if tt.incoming != "" {
+				req.Header.Set("X-Span-Id", tt.incoming)
+				req.Header.Set("X-Parent-Span-Id", "spoofed")
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if !isValidSpanID(got.SpanID) || got.SpanID == tt.incoming {
+				t.Fatalf("expected a new span ID, got %q", got.SpanID)
+			}
+			if spanHeader != got.SpanID {
+				t.Fatalf("wanted span header %q, got %q", got.SpanID, spanHeader)
+			}
+			if got.ParentSpanID != tt.wantParent || parentHeader != tt.wantParent {
+				t.Fatalf("wanted parent span %q, got %q (header %q)", tt.wantParent, got.ParentSpanID, parentHeader)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	staleIdPolicyRegenerate = "regenerate"
+	staleIdPolicyReject     = "reject"
+	staleIdPolicyTag        = "tag"
+)
+
+// parseStaleIdConfig validates maxIdAge, maxClockSkew (Go durations, empty = no
+// check) and staleIdPolicy.
+func parseStaleIdConfig(config *Config) (maxAge, maxSkew time.Duration, policy string, err error) {
+	if config.MaxIdAge != "" {
+		if maxAge, err = time.ParseDuration(config.MaxIdAge); err != nil || maxAge < 0 {
+			return 0, 0, "", fmt.Errorf("invalid max id age %q", config.MaxIdAge)
+		}
+	}
+	if config.MaxClockSkew != "" {
+		if maxSkew, err = time.ParseDuration(config.MaxClockSkew); err != nil || maxSkew < 0 {
+			return 0, 0, "", fmt.Errorf("invalid max clock skew %q", config.MaxClockSkew)
+		}
+	}
+	policy = strings.ToLower(config.StaleIdPolicy)
+	switch policy {
+	case "":
+		policy = staleIdPolicyRegenerate
+	case staleIdPolicyRegenerate, staleIdPolicyReject, staleIdPolicyTag:
+	default:
+		return 0, 0, "", fmt.Errorf("only stale id policy of regenerate, reject, or tag is supported")
+	}
+	return maxAge, maxSkew, policy, nil
+}
+
+// checkIdAge looks at the time embedded in a propagated UUIDv7, ULID or KSUID
+// trace ID. It returns "past" or "future" when it is off by more than allowed, ""
+// when it's fine or carries no time.
+func (t *TraceIDHeader) checkIdAge(traceID TraceID, now time.Time) string {
+	if traceID.Source != TraceIDSourcePropagated || (t.maxIdAge == 0 && t.maxClockSkew == 0) {
+		return ""
+	}
+	ts, ok := traceID.Time()
+	if !ok {
+		return ""
+	}
+	maxAge, maxSkew := t.maxIdAge, t.maxClockSkew
+	if maxAge == 0 {
+		maxAge = time.Duration(1<<63 - 1)
+	}
+	if maxSkew == 0 {
+		maxSkew = time.Duration(1<<63 - 1)
+	}
+	return traceTimePlausibility(ts, now, maxAge, maxSkew)
+}
+
+// applyStaleIdPolicy handles a propagated trace ID that failed checkIdAge. It
+// returns the trace ID to go on with, or false when the request was rejected.
+func (t *TraceIDHeader) applyStaleIdPolicy(rw http.ResponseWriter, req *http.Request, traceID TraceID, off string, now time.Time) (TraceID, bool) {
+	attrs := append(requestLogAttrs(req, traceID.Value), slog.String("off", off), slog.String("policy", t.staleIdPolicy))
+	if ts, ok := traceID.Time(); ok {
+		attrs = append(attrs, slog.String("traceTime", ts.Format(time.RFC3339Nano)))
+	}
+	t.logger.Info("stale trace id", attrs...)
+
+	switch t.staleIdPolicy {
+	case staleIdPolicyReject:
+		t.metrics.inc(metricStaleIds, "middleware", t.name, "outcome", "rejected", "off", off)
+		http.Error(rw, "trace id is too old or in the future", http.StatusBadRequest)
+		return traceID, false
+	case staleIdPolicyTag:
+		t.metrics.inc(metricStaleIds, "middleware", t.name, "outcome", "tagged", "off", off)
+		req.Header.Set(t.headerName+"-Stale", off)
+		return traceID, true
+	default:
+		t.metrics.inc(metricStaleIds, "middleware", t.name, "outcome", "regenerated", "off", off)
+		return t.newTraceID(now), true
+	}
+}
@@ -0,0 +1,112 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdwiegand/traefik-add-trace-id-header-2/ulid"
+)
+
+func TestStaleIdPolicy(t *testing.T) {
+	now := time.Now()
+	fresh := ulid.MustNew(ulid.Timestamp(now.Add(-time.Minute)), ulid.DefaultEntropy()).String()
+	old := ulid.MustNew(ulid.Timestamp(now.Add(-2*time.Hour)), ulid.DefaultEntropy()).String()
+	future := ulid.MustNew(ulid.Timestamp(now.Add(time.Hour)), ulid.DefaultEntropy()).String()
+
+	tests := []struct {
+		name       string
+		policy     string
+		incoming   string
+		wantStatus int
+		wantSame   bool
+		wantStale  string
+	}{
+		{name: "fresh id is kept", policy: "regenerate", incoming: fresh, wantStatus: http.StatusOK, wantSame: true},
+		{name: "old id is regenerated", policy: "regenerate", incoming: old, wantStatus: http.StatusOK},
+		{name: "future id is regenerated by default", incoming: future, wantStatus: http.StatusOK},
+		{name: "old id is rejected", policy: "reject", incoming: old, wantStatus: http.StatusBadRequest},
+		{name: "future id is tagged", policy: "Tag", incoming: future, wantStatus: http.StatusOK, wantSame: true, wantStale: traceTimeFuture},
+		{name: "uuidv4 has no age", policy: "reject", incoming: "f47ac10b-58cc-4372-a567-0e02b2c3d479", wantStatus: http.StatusOK, wantSame: true},
+		{name: "old ksuid is rejected", policy: "reject", incoming: "0ujtsYcgvSTl8PAuAdqWYSMnLOv", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var got, stale string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got = req.Header.Get("X-Trace-Id")
+				stale = req.Header.Get("X-Trace-Id-Stale")
+			})
+			config := &Config{UuidGen: "L", TrustAllIPs: true, MaxIdAge: "1h", MaxClockSkew: "1m", StaleIdPolicy: tt.policy}
+			handler, err := New(ctx, next, config, "trace-id-test")
+			if err != nil {
+				t.Fatalf("error creating new plugin instance: %+v", err)
+			}
+			recorder := httptest.NewRecorder()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+			if err != nil {
+				t.Fatalf("error with request: %+v", err)
+			}
+			req.Header.Set("X-Trace-Id", tt.incoming)
+			req.Header.Set("X-Trace-Id-Stale", "spoofed")
+
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, recorder.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if got != "" {
+					t.Fatal("a rejected request must not be passed upstream")
+				}
+				return
+			}
+			if (got == tt.incoming) != tt.wantSame {
+				t.Fatalf("incoming %q, upstream got %q", tt.incoming, got)
+			}
+			if stale != tt.wantStale {
+				t.Fatalf("expected stale header %q, got %q", tt.wantStale, stale)
+			}
+		})
+	}
+}
+
+func TestNewRejectsBadStaleIdConfig(t *testing.T) {
+	for _, config := range []*Config{
+		{MaxIdAge: "a week"},
+		{MaxClockSkew: "-1m"},
+		{StaleIdPolicy: "ignore"},
+	} {
+		_, err := New(context.Background(), http.NotFoundHandler(), config, "trace-id-test")
+		if err == nil {
+			t.Fatalf("expected an error for %+v", config)
+		}
+	}
+}
+
+func TestStaleIdMetrics(t *testing.T) {
+	ctx := context.Background()
+	config := &Config{UuidGen: "L", TrustAllIPs: true, MaxIdAge: "1h", MetricsPath: "/metrics"}
+	handler, err := New(ctx, http.NotFoundHandler(), config, "stale-metrics-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+	withOwnMetrics(handler)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+	req.Header.Set("X-Trace-Id", "01ARZ3NDEKTSV4RRFFQ69G5FAV")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var out strings.Builder
+	handler.(*TraceIDHeader).metrics.writeTo(&out)
+	want := `traceid_stale_ids_total{middleware="stale-metrics-test",outcome="regenerated",off="past"} 1`
+	if !strings.Contains(out.String(), want) {
+		t.Fatalf("metrics are missing %q:\n%s", want, out.String())
+	}
+}
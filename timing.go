@@ -0,0 +1,49 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	requestStartUnitMicros = "us"
+	requestStartUnitMillis = "ms"
+)
+
+// setRequestStart sets X-Request-Start: t=<unix time> as used by APM tools to
+// show queue time, from the same clock reading the trace ID was generated
+// with. A value set by a trusted proxy in front of us is earlier and kept.
+func (t *TraceIDHeader) setRequestStart(req *http.Request, start time.Time) {
+	if req.Header.Get("X-Request-Start") != "" && t.isTrusted(req) {
+		return
+	}
+	ts := start.UnixMicro()
+	if t.requestStartUnit == requestStartUnitMillis {
+		ts = start.UnixMilli()
+	}
+	req.Header.Set("X-Request-Start", "t="+strconv.FormatInt(ts, 10))
+}
+
+// addServerTiming appends our entry to Server-Timing, keeping upstream's own
+// entries. dur is the time from receiving the request until upstream's
+// response headers, which is when the wrapper gets to add headers.
+func (t *TraceIDHeader) addServerTiming(hdr http.Header, traceValue string, dur time.Duration) {
+	desc := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(traceValue)
+	hdr.Add("Server-Timing", t.serverTimingName+";dur="+strconv.FormatFloat(durationMs(dur), 'f', -1, 64)+`;desc="`+desc+`"`)
+}
+
+// setTraceResponse sets the W3C Trace Context Level 2 traceresponse header:
+// version-traceid-childid-flags. Values we can't express as 128 bits are skipped.
+func (t *TraceIDHeader) setTraceResponse(hdr http.Header, traceID TraceID) {
+	traceHex := traceID.Hex()
+	if traceHex == "" {
+		return
+	}
+	flags := "00"
+	if traceID.Sampled {
+		flags = "01"
+	}
+	hdr.Set("traceresponse", "00-"+traceHex+"-"+traceID.SpanID+"-"+flags)
+}
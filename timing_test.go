@@ -0,0 +1,125 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerTimingAndTraceResponse(t *testing.T) {
+	ctx := context.Background()
+
+	var traceID TraceID
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		traceID, _ = TraceIDFromContext(req.Context())
+		rw.Header().Set("Server-Timing", "db;dur=3")
+		time.Sleep(5 * time.Millisecond)
+	})
+	config := &Config{UuidGen: "L", ServerTiming: true, TraceResponse: true}
+	handler, err := New(ctx, next, config, "trace-id-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, req)
+	resp := recorder.Result()
+
+	timings := resp.Header.Values("Server-Timing")
+	if len(timings) != 2 || timings[0] != "db;dur=3" {
+		t.Fatalf("upstream Server-Timing should be kept, got %q", timings)
+	}
+	match := regexp.MustCompile(`^traefik;dur=([0-9.]+);desc="` + traceID.Value + `"$`).FindStringSubmatch(timings[1])
+	if match == nil {
+		t.Fatalf("unexpected Server-Timing entry %q", timings[1])
+	}
+	if dur, err := strconv.ParseFloat(match[1], 64); err != nil || dur < 5 {
+		t.Fatalf("duration %s should cover the upstream call", match[1])
+	}
+
+	traceResponse := resp.Header.Get("traceresponse")
+	if !regexp.MustCompile(`^00-` + traceID.Hex() + `-[0-9a-f]{16}-00$`).MatchString(traceResponse) {
+		t.Fatalf("unexpected traceresponse %q for trace ID %s", traceResponse, traceID.Value)
+	}
+}
+
+func TestTraceIDHex(t *testing.T) {
+	testMe := &TraceIDHeader{}
+	traceID := testMe.parseTraceValue("0191c5a2-3f7b-7cc3-9f3e-3a1b2c3d4e5f", TraceIDSourcePropagated)
+	if got := traceID.Hex(); got != "0191c5a23f7b7cc39f3e3a1b2c3d4e5f" {
+		t.Fatalf("unexpected hex %q", got)
+	}
+	if got := testMe.parseTraceValue("abc", TraceIDSourcePropagated).Hex(); got != "" {
+		t.Fatalf("unknown formats should have no hex form, got %q", got)
+	}
+}
+
+func TestRequestStart(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *Config
+		incoming string
+		divisor  int64 // from the header value to milliseconds, 0 = expect incoming
+	}{
+		{name: "microseconds line up with uuidv7", config: &Config{UuidGen: "7"}, divisor: 1000},
+		{name: "milliseconds line up with ulid", config: &Config{UuidGen: "L", RequestStartUnit: "MS"}, divisor: 1},
+		{name: "untrusted value is replaced", config: &Config{UuidGen: "7"}, incoming: "t=1", divisor: 1000},
+		{name: "trusted proxy value is kept", config: &Config{UuidGen: "7", TrustAllIPs: true}, incoming: "t=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			tt.config.RequestStart = true
+
+			var got string
+			var traceID TraceID
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got = req.Header.Get("X-Request-Start")
+				traceID, _ = TraceIDFromContext(req.Context())
+			})
+			handler, err := New(ctx, next, tt.config, "trace-id-test")
+			if err != nil {
+				t.Fatalf("error creating new plugin instance: %+v", err)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+			if err != nil {
+				t.Fatalf("error with request: %+v", err)
+			}
+			if tt.incoming != "" {
+				req.Header.Set("X-Request-Start", tt.incoming)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if tt.divisor == 0 {
+				if got != tt.incoming {
+					t.Fatalf("expected %q to be kept, got %q", tt.incoming, got)
+				}
+				return
+			}
+			value, err := strconv.ParseInt(strings.TrimPrefix(got, "t="), 10, 64)
+			if err != nil || !strings.HasPrefix(got, "t=") {
+				t.Fatalf("unexpected X-Request-Start %q", got)
+			}
+			ts, ok := traceID.Time()
+			if !ok || ts.UnixMilli() != value/tt.divisor {
+				t.Fatalf("X-Request-Start %q does not line up with the trace ID time %v", got, ts)
+			}
+		})
+	}
+}
+
+func TestNewRejectsUnknownRequestStartUnit(t *testing.T) {
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{RequestStartUnit: "ns"}, "trace-id-test")
+	if err == nil {
+		t.Fatal("expected an error for an unknown request start unit")
+	}
+}
@@ -3,9 +3,12 @@ package traefik_add_trace_id_header_2
 import (
 	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/cdwiegand/traefik-add-trace-id-header-2/ulid"
 	"github.com/cdwiegand/traefik-add-trace-id-header-2/uuid"
@@ -13,38 +16,261 @@ import (
 
 const defaultHeaderName = "X-Trace-Id"
 
+// incoming trace IDs longer than this are replaced, even from trusted clients
+const maxIncomingTraceIdLength = 128
+
+// what to do when the upstream response already carries the response header
+const (
+	responsePolicyKeep      = "keep"      // leave upstream's value alone
+	responsePolicyOverwrite = "overwrite" // replace upstream's value with ours
+	responsePolicyBoth      = "both"      // emit upstream's value and ours
+)
+
 // Config the plugin configuration.
 type Config struct {
-	ValuePrefix   string `json:"valuePrefix"`
-	ValueSuffix   string `json:"valueSuffix"`
-	HeaderName    string `json:"headerName"`
-	Verbose       bool   `json:"verbose"`
-	UuidGen       string `json:"uuidGen"`
-	AddToResponse bool   `json:"addToResponse"`
+	ValuePrefix          string            `json:"valuePrefix"`
+	ValueSuffix          string            `json:"valueSuffix"`
+	HeaderName           string            `json:"headerName"`
+	Verbose              bool              `json:"verbose"`
+	UuidGen              string            `json:"uuidGen"`
+	AddToResponse        bool              `json:"addToResponse"`
+	ResponseHeaderName   string            `json:"responseHeaderName"`
+	ResponseHeaderPolicy string            `json:"responseHeaderPolicy"`
+	CorrelateUpstream    bool              `json:"correlateUpstream"`
+	UpstreamHeaderName   string            `json:"upstreamHeaderName"`
+	ExposeUpstreamHeader string            `json:"exposeUpstreamHeader"`
+	TrustAllIPs          bool              `json:"trustAllIPs"`
+	TrustNetworks        []string          `json:"trustNetworks"`
+	LogFormat            string            `json:"logFormat"`
+	LogLevel             string            `json:"logLevel"`
+	LogOutput            string            `json:"logOutput"`
+	AccessLog            bool              `json:"accessLog"`
+	AccessLogFormat      string            `json:"accessLogFormat"`
+	SlowRequestMs        int               `json:"slowRequestMs"`
+	LogStatuses          []string          `json:"logStatuses"`
+	LogRequestHeaders    []string          `json:"logRequestHeaders"`
+	ProblemLogRate       int               `json:"problemLogRate"`
+	ErrorBodyStatuses    []string          `json:"errorBodyStatuses"`
+	ErrorBodyTypes       []string          `json:"errorBodyTypes"`
+	ErrorBodyField       string            `json:"errorBodyField"`
+	RecoverPanics        bool              `json:"recoverPanics"`
+	ExposeHeaders        bool              `json:"exposeHeaders"`
+	ExtraExposeHeaders   []string          `json:"extraExposeHeaders"`
+	ServerTiming         bool              `json:"serverTiming"`
+	ServerTimingName     string            `json:"serverTimingName"`
+	TraceResponse        bool              `json:"traceResponse"`
+	Correlation          bool              `json:"correlation"`
+	CorrelationHeader    string            `json:"correlationHeader"`
+	CorrelationCookie    string            `json:"correlationCookie"`
+	CorrelationSecure    bool              `json:"correlationSecure"`
+	CorrelationHttpOnly  bool              `json:"correlationHttpOnly"`
+	CorrelationSameSite  string            `json:"correlationSameSite"`
+	CorrelationMaxAge    int               `json:"correlationMaxAge"`
+	TraceIdQueryParam    string            `json:"traceIdQueryParam"`
+	TraceIdCookie        string            `json:"traceIdCookie"`
+	StripQueryParam      bool              `json:"stripQueryParam"`
+	SpanIds              bool              `json:"spanIds"`
+	SpanHeaderName       string            `json:"spanHeaderName"`
+	ParentSpanHeaderName string            `json:"parentSpanHeaderName"`
+	Hierarchical         bool              `json:"hierarchical"`
+	HierarchyMaxLength   int               `json:"hierarchyMaxLength"`
+	HierarchyMaxDepth    int               `json:"hierarchyMaxDepth"`
+	Sampler              string            `json:"sampler"`
+	SampleRatio          float64           `json:"sampleRatio"`
+	SamplingPropagation  []string          `json:"samplingPropagation"`
+	SampledHeaderName    string            `json:"sampledHeaderName"`
+	ServiceName          string            `json:"serviceName"`
+	OtlpEndpoint         string            `json:"otlpEndpoint"`
+	OtlpProtocol         string            `json:"otlpProtocol"`
+	OtlpHeaders          map[string]string `json:"otlpHeaders"`
+	ExportBatchSize      int               `json:"exportBatchSize"`
+	ExportQueueSize      int               `json:"exportQueueSize"`
+	ExportIntervalMs     int               `json:"exportIntervalMs"`
+	ExportMaxRetries     int               `json:"exportMaxRetries"`
+	ZipkinEndpoint       string            `json:"zipkinEndpoint"`
+	EventLogFile         string            `json:"eventLogFile"`
+	EventLogMaxSizeMb    int               `json:"eventLogMaxSizeMb"`
+	EventLogMaxBackups   int               `json:"eventLogMaxBackups"`
+	EventLogQueueSize    int               `json:"eventLogQueueSize"`
+	MetricsPath          string            `json:"metricsPath"`
+	RecentRequests       int               `json:"recentRequests"`
+	LookupPath           string            `json:"lookupPath"`
+	LookupNetworks       []string          `json:"lookupNetworks"`
+	DecodePath           string            `json:"decodePath"`
+	TraceTime            bool              `json:"traceTime"`
+	TraceTimeHeaderName  string            `json:"traceTimeHeaderName"`
+	MaxIdAge             string            `json:"maxIdAge"`
+	MaxClockSkew         string            `json:"maxClockSkew"`
+	StaleIdPolicy        string            `json:"staleIdPolicy"`
+	RequestStart         bool              `json:"requestStart"`
+	RequestStartUnit     string            `json:"requestStartUnit"`
+	StripUntrusted       bool              `json:"stripUntrusted"`
+	StripPropagators     []string          `json:"stripPropagators"`
+	StripHeaders         []string          `json:"stripHeaders"`
 }
 
 // CreateConfig creates the DEFAULT plugin configuration - no access to config yet!
 func CreateConfig() *Config {
 	return &Config{
-		ValuePrefix:   "",
-		ValueSuffix:   "",
-		HeaderName:    defaultHeaderName,
-		Verbose:       false,
-		UuidGen:       "4", // 4 = UUIDv4, 7 = UUIDv7, L = ULID
-		AddToResponse: true,
+		ValuePrefix:          "",
+		ValueSuffix:          "",
+		HeaderName:           defaultHeaderName,
+		Verbose:              false,
+		UuidGen:              "4", // 4 = UUIDv4, 7 = UUIDv7, L = ULID
+		AddToResponse:        true,
+		ResponseHeaderName:   "", // empty = same as HeaderName
+		ResponseHeaderPolicy: responsePolicyKeep,
+		CorrelateUpstream:    false,
+		UpstreamHeaderName:   "", // empty = same as ResponseHeaderName
+		ExposeUpstreamHeader: "", // e.g. X-Upstream-Trace-Id, empty = don't expose
+		TrustAllIPs:          false,
+		TrustNetworks:        []string{},
+		LogFormat:            logFormatText, // text or json
+		LogLevel:             "info",
+		LogOutput:            logOutputStderr, // stdout, stderr or a file path
+		AccessLog:            false,
+		AccessLogFormat:      accessLogFormatCommon, // common, combined or json
+		SlowRequestMs:        0,                     // 0 = don't log slow requests
+		LogStatuses:          []string{},            // e.g. 5xx, 429
+		LogRequestHeaders:    []string{},
+		ProblemLogRate:       0,          // max slow/problem lines per second, 0 = unlimited
+		ErrorBodyStatuses:    []string{}, // e.g. 502, 503, 504, empty = never touch bodies
+		ErrorBodyTypes:       defaultErrorBodyContentTypes,
+		ErrorBodyField:       "traceId",
+		RecoverPanics:        false,
+		ExposeHeaders:        false,
+		ExtraExposeHeaders:   []string{},
+		ServerTiming:         false,
+		ServerTimingName:     "traefik",
+		TraceResponse:        false,
+		Correlation:          false,
+		CorrelationHeader:    defaultCorrelationHeaderName,
+		CorrelationCookie:    defaultCorrelationCookieName,
+		CorrelationSecure:    false,
+		CorrelationHttpOnly:  true,
+		CorrelationSameSite:  "lax", // lax, strict or none
+		CorrelationMaxAge:    0,     // seconds, 0 = until the browser is closed
+		TraceIdQueryParam:    "",    // empty = don't look at the query string
+		TraceIdCookie:        "",    // empty = don't look at cookies
+		StripQueryParam:      false,
+		SpanIds:              false,
+		SpanHeaderName:       defaultSpanHeaderName,
+		ParentSpanHeaderName: defaultParentSpanHeaderName,
+		Hierarchical:         false,
+		HierarchyMaxLength:   defaultHierarchyMaxLength,
+		HierarchyMaxDepth:    defaultHierarchyMaxDepth, // hops appended to the root
+		Sampler:              "",                       // always, never, ratio or parent, empty = no sampling decision
+		SampleRatio:          1,
+		SamplingPropagation:  []string{samplingPropagationHeader}, // traceparent, b3 and/or header
+		SampledHeaderName:    defaultSampledHeaderName,
+		ServiceName:          "", // empty = middleware name
+		OtlpEndpoint:         "", // e.g. http://collector:4318/v1/traces, empty = don't export
+		OtlpProtocol:         otlpProtocolJSON,
+		OtlpHeaders:          map[string]string{},
+		ExportBatchSize:      defaultExportBatchSize,
+		ExportQueueSize:      defaultExportQueueSize,
+		ExportIntervalMs:     defaultExportIntervalMs,
+		ExportMaxRetries:     defaultExportMaxRetries,
+		ZipkinEndpoint:       "", // e.g. http://zipkin:9411/api/v2/spans, empty = don't export
+		EventLogFile:         "", // empty = no event log
+		EventLogMaxSizeMb:    defaultEventLogMaxSizeMb,
+		EventLogMaxBackups:   defaultEventLogMaxBackups,
+		EventLogQueueSize:    defaultEventLogQueueSize,
+		MetricsPath:          "", // e.g. /metrics, empty = no metrics
+		RecentRequests:       0,  // 0 = don't remember requests
+		LookupPath:           "", // e.g. /_trace/, empty = no lookup endpoint
+		LookupNetworks:       defaultLookupNetworks,
+		DecodePath:           "", // e.g. /.well-known/trace-id/, empty = no decode endpoint
+		TraceTime:            false,
+		TraceTimeHeaderName:  defaultTraceTimeHeaderName,
+		MaxIdAge:             "",                      // e.g. 1h, empty = any age
+		MaxClockSkew:         "",                      // e.g. 1m, empty = any time in the future
+		StaleIdPolicy:        staleIdPolicyRegenerate, // regenerate, reject or tag
+		RequestStart:         false,
+		RequestStartUnit:     requestStartUnitMicros, // us or ms
+		StripUntrusted:       false,
+		StripPropagators:     defaultStripPropagators,
+		StripHeaders:         []string{},
 	}
 }
 
 // TraceIDHeader header
 type TraceIDHeader struct {
-	valuePrefix   string
-	valueSuffix   string
-	headerName    string
-	verbose       bool
-	uuidGen       string
-	addToResponse bool
-	name          string
-	next          http.Handler
+	valuePrefix               string
+	valueSuffix               string
+	headerName                string
+	verbose                   bool
+	uuidGen                   string
+	addToResponse             bool
+	responseHeaderName        string
+	responseHeaderPolicy      string
+	correlateUpstream         bool
+	upstreamHeaderName        string
+	exposeUpstreamHeader      string
+	trustAllIPs               bool
+	trustNetworks             []*net.IPNet
+	logger                    *slog.Logger
+	logOutput                 io.Writer
+	accessLog                 bool
+	accessLogFormat           string
+	slowRequestThreshold      time.Duration
+	logStatuses               []statusMatcher
+	logRequestHeaders         []string
+	problemLogLimiter         *logRateLimiter
+	errorBodyStatuses         []statusMatcher
+	errorBodyContentTypes     []string
+	errorBodyField            string
+	recoverPanics             bool
+	exposeHeaders             bool
+	exposedHeaders            []string
+	serverTiming              bool
+	serverTimingName          string
+	traceResponse             bool
+	correlation               bool
+	correlationHeaderName     string
+	correlationCookieName     string
+	correlationCookieSecure   bool
+	correlationCookieHttpOnly bool
+	correlationCookieSameSite http.SameSite
+	correlationCookieMaxAge   int
+	traceIdQueryParam         string
+	traceIdCookie             string
+	stripQueryParam           bool
+	spanIds                   bool
+	spanHeaderName            string
+	parentSpanHeaderName      string
+	hierarchical              bool
+	hierarchyMaxLength        int
+	hierarchyMaxDepth         int
+	sampler                   string
+	sampleRatio               float64
+	samplingPropagation       []string
+	sampledHeaderName         string
+	serviceName               string
+	otlpEndpoint              string
+	otlpProtocol              string
+	otlpHeaders               map[string]string
+	zipkinEndpoint            string
+	exportClient              *http.Client
+	spanExporters             []*spanBatcher
+	eventLog                  *eventSink
+	metricsPath               string
+	metrics                   *metricsRegistry
+	recent                    *recentRequests
+	lookupPath                string
+	lookupNetworks            []*net.IPNet
+	decodePath                string
+	traceTime                 bool
+	traceTimeHeaderName       string
+	maxIdAge                  time.Duration
+	maxClockSkew              time.Duration
+	staleIdPolicy             string
+	requestStart              bool
+	requestStartUnit          string
+	stripUntrusted            bool
+	stripHeaders              []string
+	name                      string
+	next                      http.Handler
 }
 
 // New created a new TraceIDHeader plugin, with a config that's been set (possibly) by the admin
@@ -59,20 +285,244 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 	if config.UuidGen != "4" && config.UuidGen != "7" && config.UuidGen != "L" {
 		return nil, fmt.Errorf("only uuid gen value of 4 (UUIDv4), 7 (UUIDv7), or L (ULID) is supported")
 	}
+	if config.ResponseHeaderPolicy == "" {
+		config.ResponseHeaderPolicy = responsePolicyKeep
+	}
+	config.ResponseHeaderPolicy = strings.ToLower(config.ResponseHeaderPolicy)
+	if config.ResponseHeaderPolicy != responsePolicyKeep && config.ResponseHeaderPolicy != responsePolicyOverwrite && config.ResponseHeaderPolicy != responsePolicyBoth {
+		return nil, fmt.Errorf("only response header policy of keep, overwrite, or both is supported")
+	}
+	trustNetworks, err := parseTrustNetworks(config.TrustNetworks)
+	if err != nil {
+		return nil, err
+	}
+	if config.AccessLogFormat == "" {
+		config.AccessLogFormat = accessLogFormatCommon
+	}
+	config.AccessLogFormat = strings.ToLower(config.AccessLogFormat)
+	if config.AccessLogFormat != accessLogFormatCommon && config.AccessLogFormat != accessLogFormatCombined && config.AccessLogFormat != accessLogFormatJSON {
+		return nil, fmt.Errorf("only access log format of common, combined, or json is supported")
+	}
+	if config.MetricsPath != "" && !strings.HasPrefix(config.MetricsPath, "/") {
+		return nil, fmt.Errorf("metrics path must start with /")
+	}
+	if config.LookupPath != "" && !strings.HasPrefix(config.LookupPath, "/") {
+		return nil, fmt.Errorf("lookup path must start with /")
+	}
+	if config.DecodePath != "" && !strings.HasPrefix(config.DecodePath, "/") {
+		return nil, fmt.Errorf("decode path must start with /")
+	}
+	config.RequestStartUnit = strings.ToLower(config.RequestStartUnit)
+	if config.RequestStartUnit == "" {
+		config.RequestStartUnit = requestStartUnitMicros
+	}
+	if config.RequestStartUnit != requestStartUnitMicros && config.RequestStartUnit != requestStartUnitMillis {
+		return nil, fmt.Errorf("only request start unit of us or ms is supported")
+	}
+	stripHeaders, err := parseStripHeaders(config.StripPropagators, config.StripHeaders)
+	if err != nil {
+		return nil, err
+	}
+	maxIdAge, maxClockSkew, staleIdPolicy, err := parseStaleIdConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.LookupNetworks) == 0 {
+		config.LookupNetworks = defaultLookupNetworks
+	}
+	lookupNetworks, err := parseTrustNetworks(config.LookupNetworks)
+	if err != nil {
+		return nil, err
+	}
+	logStatuses, err := parseStatusMatchers(config.LogStatuses)
+	if err != nil {
+		return nil, err
+	}
+	errorBodyStatuses, err := parseStatusMatchers(config.ErrorBodyStatuses)
+	if err != nil {
+		return nil, err
+	}
+	correlationSameSite, err := parseSameSite(config.CorrelationSameSite)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSampling(config); err != nil {
+		return nil, err
+	}
+	if err := validateOTLP(config); err != nil {
+		return nil, err
+	}
+	logOutput, err := openLogOutput(ctx, config.LogOutput)
+	if err != nil {
+		return nil, err
+	}
+	logger, err := newLogger(config, name, logOutput)
+	if err != nil {
+		closeLogOutput(logOutput)
+		return nil, err
+	}
+	// the last thing that can fail, so nothing below has to be undone: the
+	// exporters' goroutines only stop once ctx is done
+	var eventLog *eventSink
+	if config.EventLogFile != "" {
+		eventLog, err = newEventSink(ctx, config.EventLogFile, config.EventLogMaxSizeMb, config.EventLogMaxBackups, config.EventLogQueueSize, logger)
+		if err != nil {
+			closeLogOutput(logOutput)
+			return nil, err
+		}
+	}
 
 	tIDHdr := &TraceIDHeader{
-		valuePrefix:   config.ValuePrefix,
-		valueSuffix:   config.ValueSuffix,
-		headerName:    config.HeaderName,
-		verbose:       config.Verbose,
-		uuidGen:       config.UuidGen,
-		addToResponse: config.AddToResponse,
-		next:          next,
-		name:          name,
+		valuePrefix:               config.ValuePrefix,
+		valueSuffix:               config.ValueSuffix,
+		headerName:                config.HeaderName,
+		verbose:                   config.Verbose,
+		uuidGen:                   config.UuidGen,
+		addToResponse:             config.AddToResponse,
+		responseHeaderName:        config.ResponseHeaderName,
+		responseHeaderPolicy:      config.ResponseHeaderPolicy,
+		correlateUpstream:         config.CorrelateUpstream,
+		upstreamHeaderName:        config.UpstreamHeaderName,
+		exposeUpstreamHeader:      config.ExposeUpstreamHeader,
+		trustAllIPs:               config.TrustAllIPs,
+		trustNetworks:             trustNetworks,
+		logger:                    logger,
+		logOutput:                 logOutput,
+		eventLog:                  eventLog,
+		accessLog:                 config.AccessLog,
+		accessLogFormat:           config.AccessLogFormat,
+		slowRequestThreshold:      time.Duration(config.SlowRequestMs) * time.Millisecond,
+		logStatuses:               logStatuses,
+		logRequestHeaders:         config.LogRequestHeaders,
+		problemLogLimiter:         newLogRateLimiter(config.ProblemLogRate),
+		errorBodyStatuses:         errorBodyStatuses,
+		errorBodyContentTypes:     config.ErrorBodyTypes,
+		errorBodyField:            config.ErrorBodyField,
+		recoverPanics:             config.RecoverPanics,
+		exposeHeaders:             config.ExposeHeaders && config.AddToResponse,
+		serverTiming:              config.ServerTiming,
+		serverTimingName:          config.ServerTimingName,
+		traceResponse:             config.TraceResponse,
+		correlation:               config.Correlation,
+		correlationHeaderName:     config.CorrelationHeader,
+		correlationCookieName:     config.CorrelationCookie,
+		correlationCookieSecure:   config.CorrelationSecure,
+		correlationCookieHttpOnly: config.CorrelationHttpOnly,
+		correlationCookieSameSite: correlationSameSite,
+		correlationCookieMaxAge:   config.CorrelationMaxAge,
+		traceIdQueryParam:         config.TraceIdQueryParam,
+		traceIdCookie:             config.TraceIdCookie,
+		stripQueryParam:           config.StripQueryParam && config.TraceIdQueryParam != "",
+		spanIds:                   config.SpanIds,
+		spanHeaderName:            config.SpanHeaderName,
+		parentSpanHeaderName:      config.ParentSpanHeaderName,
+		hierarchical:              config.Hierarchical,
+		hierarchyMaxLength:        config.HierarchyMaxLength,
+		hierarchyMaxDepth:         config.HierarchyMaxDepth,
+		sampler:                   strings.ToLower(config.Sampler),
+		sampleRatio:               config.SampleRatio,
+		sampledHeaderName:         config.SampledHeaderName,
+		serviceName:               config.ServiceName,
+		otlpEndpoint:              config.OtlpEndpoint,
+		otlpProtocol:              strings.ToLower(config.OtlpProtocol),
+		otlpHeaders:               config.OtlpHeaders,
+		zipkinEndpoint:            config.ZipkinEndpoint,
+		metricsPath:               config.MetricsPath,
+		lookupPath:                config.LookupPath,
+		lookupNetworks:            lookupNetworks,
+		decodePath:                config.DecodePath,
+		traceTime:                 config.TraceTime,
+		traceTimeHeaderName:       config.TraceTimeHeaderName,
+		maxIdAge:                  maxIdAge,
+		maxClockSkew:              maxClockSkew,
+		staleIdPolicy:             staleIdPolicy,
+		requestStart:              config.RequestStart,
+		requestStartUnit:          config.RequestStartUnit,
+		stripUntrusted:            config.StripUntrusted,
+		stripHeaders:              stripHeaders,
+		exportClient:              &http.Client{Timeout: 10 * time.Second},
+		next:                      next,
+		name:                      name,
 	}
 	if tIDHdr.headerName == "" {
 		tIDHdr.headerName = defaultHeaderName
 	}
+	if tIDHdr.responseHeaderName == "" {
+		tIDHdr.responseHeaderName = tIDHdr.headerName
+	}
+	if tIDHdr.upstreamHeaderName == "" {
+		tIDHdr.upstreamHeaderName = tIDHdr.responseHeaderName
+	}
+	if len(tIDHdr.errorBodyContentTypes) == 0 {
+		tIDHdr.errorBodyContentTypes = defaultErrorBodyContentTypes
+	}
+	if tIDHdr.errorBodyField == "" {
+		tIDHdr.errorBodyField = "traceId"
+	}
+	if tIDHdr.serverTimingName == "" {
+		tIDHdr.serverTimingName = "traefik"
+	}
+	if tIDHdr.correlationHeaderName == "" {
+		tIDHdr.correlationHeaderName = defaultCorrelationHeaderName
+	}
+	if tIDHdr.correlationCookieName == "" {
+		tIDHdr.correlationCookieName = defaultCorrelationCookieName
+	}
+	if tIDHdr.spanHeaderName == "" {
+		tIDHdr.spanHeaderName = defaultSpanHeaderName
+	}
+	if tIDHdr.parentSpanHeaderName == "" {
+		tIDHdr.parentSpanHeaderName = defaultParentSpanHeaderName
+	}
+	if tIDHdr.hierarchyMaxLength <= 0 || tIDHdr.hierarchyMaxLength > maxIncomingTraceIdLength {
+		tIDHdr.hierarchyMaxLength = defaultHierarchyMaxLength
+	}
+	if tIDHdr.hierarchyMaxDepth <= 0 {
+		tIDHdr.hierarchyMaxDepth = defaultHierarchyMaxDepth
+	}
+	for _, p := range config.SamplingPropagation {
+		tIDHdr.samplingPropagation = append(tIDHdr.samplingPropagation, strings.ToLower(p))
+	}
+	if len(tIDHdr.samplingPropagation) == 0 {
+		tIDHdr.samplingPropagation = []string{samplingPropagationHeader}
+	}
+	if tIDHdr.sampledHeaderName == "" {
+		tIDHdr.sampledHeaderName = defaultSampledHeaderName
+	}
+	if tIDHdr.serviceName == "" {
+		tIDHdr.serviceName = name
+	}
+	exportInterval := time.Duration(config.ExportIntervalMs) * time.Millisecond
+	if tIDHdr.otlpEndpoint != "" {
+		tIDHdr.spanExporters = append(tIDHdr.spanExporters,
+			newSpanBatcher(ctx, "otlp", tIDHdr.sendOTLP, config.ExportBatchSize, config.ExportQueueSize, exportInterval, config.ExportMaxRetries, logger))
+	}
+	if tIDHdr.zipkinEndpoint != "" {
+		tIDHdr.spanExporters = append(tIDHdr.spanExporters,
+			newSpanBatcher(ctx, "zipkin", tIDHdr.sendZipkin, config.ExportBatchSize, config.ExportQueueSize, exportInterval, config.ExportMaxRetries, logger))
+	}
+	if config.RecentRequests > 0 {
+		tIDHdr.recent = newRecentRequests(config.RecentRequests)
+		if tIDHdr.lookupPath != "" && !strings.HasSuffix(tIDHdr.lookupPath, "/") {
+			tIDHdr.lookupPath += "/"
+		}
+	}
+	if tIDHdr.traceTimeHeaderName == "" {
+		tIDHdr.traceTimeHeaderName = defaultTraceTimeHeaderName
+	}
+	if tIDHdr.decodePath != "" && !strings.HasSuffix(tIDHdr.decodePath, "/") {
+		tIDHdr.decodePath += "/"
+	}
+	if tIDHdr.metricsPath != "" {
+		tIDHdr.metrics = pluginMetrics
+		tIDHdr.registerMetricCollectors()
+	}
+	if tIDHdr.exposeHeaders {
+		tIDHdr.exposedHeaders = append([]string{tIDHdr.responseHeaderName}, config.ExtraExposeHeaders...)
+		if tIDHdr.exposeUpstreamHeader != "" {
+			tIDHdr.exposedHeaders = append(tIDHdr.exposedHeaders, tIDHdr.exposeUpstreamHeader)
+		}
+	}
 	if tIDHdr.valuePrefix == "\"\"" {
 		tIDHdr.valuePrefix = "" // means use literally typed valuePrefix: "" so interpret that as empty string, not 2 double quotes (")
 	}
@@ -84,32 +534,237 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 }
 
 func (t *TraceIDHeader) GenerateTraceId() string {
-	var traceValue string
+	return t.generateTraceID(time.Now()).Value
+}
+
+// generateTraceID makes a new trace ID, UUIDv7 and ULID embed now, the time
+// the request was received, so they line up with X-Request-Start.
+func (t *TraceIDHeader) generateTraceID(now time.Time) TraceID {
+	traceID := TraceID{Source: TraceIDSourceGenerated}
 	switch t.uuidGen {
 	case "4":
-		tmpUuid4, _ := uuid.NewV4()
-		traceValue = t.valuePrefix + tmpUuid4.String()
+		tmpUuid4, err := uuid.NewV4()
+		if err != nil {
+			t.countGeneratorFailure()
+			return t.fallbackTraceID()
+		}
+		traceID.Format = TraceIDFormatUUID
+		traceID.UUID = tmpUuid4
+		traceID.Value = t.valuePrefix + tmpUuid4.String()
 	case "7":
-		tmpUuid7, _ := uuid.NewV7()
-		traceValue = t.valuePrefix + tmpUuid7.String()
+		tmpUuid7, err := uuid.NewV7AtTime(now)
+		if err != nil {
+			t.countGeneratorFailure()
+			return t.fallbackTraceID()
+		}
+		traceID.Format = TraceIDFormatUUID
+		traceID.UUID = tmpUuid7
+		traceID.Value = t.valuePrefix + tmpUuid7.String()
 	case "L":
-		s2 := ulid.Make()
-		traceValue = t.valuePrefix + s2.String()
+		s2 := ulid.MustNew(ulid.Timestamp(now), ulid.DefaultEntropy())
+		traceID.Format = TraceIDFormatULID
+		traceID.ULID = s2
+		traceID.Value = t.valuePrefix + s2.String()
 	}
 
-	return traceValue
+	return traceID
+}
+
+// resolveTraceID keeps the trace ID sent by a trusted client (extended by a
+// segment for this hop in hierarchical mode), otherwise generates a new one.
+func (t *TraceIDHeader) resolveTraceID(req *http.Request, now time.Time) TraceID {
+	if incoming := t.incomingTraceIds(req); len(incoming) > 0 {
+		if !t.isTrusted(req) {
+			t.countIncoming(incomingOutcomeUntrusted)
+		} else {
+			for _, value := range incoming {
+				if !isValidIncomingTraceId(value) {
+					t.countIncoming(incomingOutcomeInvalid)
+					continue
+				}
+				if !t.hierarchical {
+					t.countIncoming(incomingOutcomeAccepted)
+					return t.parseTraceValue(value, TraceIDSourcePropagated)
+				}
+				if extended, ok := t.extendTraceValue(value); ok {
+					t.countIncoming(incomingOutcomeAccepted)
+					return t.parseTraceValue(extended, TraceIDSourcePropagated)
+				}
+				t.countIncoming(incomingOutcomeTooDeep)
+				break // chain got too long or deep, start over with a fresh root
+			}
+		}
+	}
+	return t.newTraceID(now)
+}
+
+// newTraceID generates a fresh trace ID, shielded from generator panics if configured.
+func (t *TraceIDHeader) newTraceID(now time.Time) TraceID {
+	if t.recoverPanics {
+		return t.generateTraceIDSafely(now)
+	}
+	return t.generateTraceID(now)
 }
 
 func (t *TraceIDHeader) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	traceValue := t.GenerateTraceId()
+	if t.isMetricsRequest(req) {
+		t.serveMetrics(rw)
+		return
+	}
+	if t.isLookupRequest(req) {
+		t.serveLookup(rw, req)
+		return
+	}
+	if t.isDecodeRequest(req) {
+		t.serveDecode(rw, req)
+		return
+	}
+	if t.stripUntrusted {
+		t.stripUntrustedHeaders(req)
+	}
+	if t.exposeHeaders && isPreflight(req) {
+		if !t.isTrusted(req) {
+			req.Header.Del(t.headerName) // preflights get no trace ID, not even one the client made up
+		}
+		t.next.ServeHTTP(rw, req)
+		return
+	}
+
+	start := time.Now()
+	traceID := t.resolveTraceID(req, start)
+	if t.maxIdAge != 0 || t.maxClockSkew != 0 {
+		req.Header.Del(t.headerName + "-Stale") // only we get to say so
+		if off := t.checkIdAge(traceID, start); off != "" {
+			var ok bool
+			if traceID, ok = t.applyStaleIdPolicy(rw, req, traceID, off, start); !ok {
+				return
+			}
+		}
+	}
+	if traceID.Value == "" {
+		// even the UUIDv4 fallback failed, better no trace ID than the nil UUID
+		req.Header.Del(t.headerName)
+		t.next.ServeHTTP(rw, req)
+		return
+	}
+	traceValue := traceID.Value
+	if t.stripQueryParam {
+		stripQueryParam(req, t.traceIdQueryParam)
+	}
 	req.Header.Set(t.headerName, traceValue)
-	if t.addToResponse {
-		rw.Header().Set(t.headerName, traceValue)
+	newCorrelation := false
+	if t.correlation {
+		traceID.CorrelationID, newCorrelation = t.resolveCorrelationID(req, start)
+		if traceID.CorrelationID != "" {
+			req.Header.Set(t.correlationHeaderName, traceID.CorrelationID)
+		} else {
+			req.Header.Del(t.correlationHeaderName)
+		}
+	}
+	if t.traceTime {
+		t.setTraceTime(req, traceID, start)
+	}
+	if t.requestStart {
+		t.setRequestStart(req, start)
 	}
+	if t.spanIds {
+		t.assignSpan(req, &traceID)
+	} else if t.needsSpanID() {
+		traceID.SpanID = newSpanID()
+	}
+	if t.sampler != "" {
+		traceID.Sampled = t.sample(req, traceID)
+		t.propagateSampling(req, traceID)
+	}
+	req = req.WithContext(contextWithTraceID(req.Context(), traceID))
 
 	if t.verbose {
-		log.Println(t.headerName + ": " + req.Header[t.headerName][0])
+		t.logger.Info("trace id assigned", append(requestLogAttrs(req, traceValue), slog.String("source", traceID.Source))...)
 	}
 
-	t.next.ServeHTTP(rw, req)
+	wrapped := newResponseWriter(rw, func(w *responseWriter, code int) {
+		if t.correlateUpstream {
+			t.correlateUpstreamTraceId(w.Header(), req, traceValue)
+		}
+		if t.addToResponse {
+			t.setResponseHeader(w.Header(), traceValue)
+		}
+		if t.serverTiming {
+			t.addServerTiming(w.Header(), traceValue, w.wroteHeaderAt.Sub(start))
+		}
+		if t.traceResponse {
+			t.setTraceResponse(w.Header(), traceID)
+		}
+		if newCorrelation {
+			w.Header().Add("Set-Cookie", t.correlationCookie(traceID.CorrelationID).String())
+		}
+		if t.exposeHeaders {
+			t.exposeTraceHeaders(w.Header())
+		}
+		if t.shouldRewriteErrorBody(req, w.Header(), code) {
+			w.bufferBody(func(body []byte) []byte {
+				return t.rewriteErrorBody(w.Header(), code, body, traceValue)
+			})
+		}
+	})
+	nextStart := time.Now()
+	t.serveNext(wrapped, req, traceValue)
+	wrapped.finish()
+	end := time.Now()
+
+	if t.accessLog {
+		t.writeAccessLog(t.logOutput, req, wrapped, traceValue, start, end.Sub(start))
+	}
+	t.logProblemRequest(req, wrapped, traceValue, start, nextStart, end)
+	t.recordRequest(traceID, end.Sub(start))
+	if t.recent != nil {
+		t.recent.add(t.newRequestSummary(req, wrapped, traceID, start, end))
+	}
+	if t.eventLog != nil {
+		t.eventLog.enqueue(t.newEventLogEntry(req, wrapped, traceID, start, end))
+	}
+
+	if len(t.spanExporters) > 0 && t.shouldExport(traceID) {
+		span := t.newSpanRecord(req, wrapped, traceID, start, end)
+		for _, exporter := range t.spanExporters {
+			exporter.enqueue(span)
+		}
+	}
+}
+
+// correlateUpstreamTraceId looks for a trace ID the upstream service put in its
+// response, logs it next to ours when they differ, and optionally exposes it.
+// Must run before setResponseHeader, which may overwrite upstream's value.
+func (t *TraceIDHeader) correlateUpstreamTraceId(hdr http.Header, req *http.Request, traceValue string) {
+	upstreamValue := hdr.Get(t.upstreamHeaderName)
+	if upstreamValue == "" || upstreamValue == traceValue {
+		return
+	}
+
+	t.logger.Info("upstream trace id differs", append(requestLogAttrs(req, traceValue), slog.String("upstreamTraceId", upstreamValue))...)
+	if t.exposeUpstreamHeader != "" {
+		hdr.Set(t.exposeUpstreamHeader, upstreamValue)
+	}
+}
+
+// setResponseHeader applies our trace ID to the response headers, honoring
+// responseHeaderPolicy when upstream already set the header itself.
+func (t *TraceIDHeader) setResponseHeader(hdr http.Header, traceValue string) {
+	upstream := hdr.Values(t.responseHeaderName)
+	if len(upstream) == 0 {
+		hdr.Set(t.responseHeaderName, traceValue)
+		return
+	}
+
+	switch t.responseHeaderPolicy {
+	case responsePolicyOverwrite:
+		hdr.Set(t.responseHeaderName, traceValue)
+	case responsePolicyBoth:
+		for _, v := range upstream {
+			if v == traceValue {
+				return // already there, don't emit it twice
+			}
+		}
+		hdr.Add(t.responseHeaderName, traceValue)
+	}
 }
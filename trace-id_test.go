@@ -40,6 +40,8 @@ func TestServeHTTP(t *testing.T) {
 	tests := []struct {
 		name       string
 		config     *Config
+		remoteAddr string
+		incoming   string
 		assertFunc func(t *testing.T) http.Handler
 	}{
 		{
@@ -53,6 +55,47 @@ func TestServeHTTP(t *testing.T) {
 				})
 			},
 		},
+		{
+			name:       "trusted client keeps its trace id",
+			config:     &Config{TrustNetworks: []string{"10.0.0.0/8"}},
+			remoteAddr: "10.1.2.3:1234",
+			incoming:   "from-trusted-client",
+			assertFunc: func(t *testing.T) http.Handler {
+				t.Helper()
+				return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+					hdr := getTraceIdHeader(t, req, "X-Trace-Id")
+					if hdr != "from-trusted-client" {
+						t.Fatalf("expected the trusted client's trace id, got %q", hdr)
+					}
+				})
+			},
+		},
+		{
+			name:       "untrusted client gets a new trace id",
+			config:     &Config{TrustNetworks: []string{"10.0.0.0/8"}},
+			remoteAddr: "192.0.2.1:1234",
+			incoming:   "from-untrusted-client",
+			assertFunc: func(t *testing.T) http.Handler {
+				t.Helper()
+				return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+					hdr := getTraceIdHeader(t, req, "X-Trace-Id")
+					mustHaveLength(t, hdr, 36)
+				})
+			},
+		},
+		{
+			name:       "trusted client with an invalid trace id gets a new one",
+			config:     &Config{TrustAllIPs: true},
+			remoteAddr: "192.0.2.1:1234",
+			incoming:   "has spaces in it",
+			assertFunc: func(t *testing.T) http.Handler {
+				t.Helper()
+				return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+					hdr := getTraceIdHeader(t, req, "X-Trace-Id")
+					mustHaveLength(t, hdr, 36)
+				})
+			},
+		},
 		{
 			name:   "no trace id",
 			config: &Config{},
@@ -132,12 +175,156 @@ func TestServeHTTP(t *testing.T) {
 			if err != nil {
 				t.Fatalf("error with request: %+v", err)
 			}
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			if tt.incoming != "" {
+				req.Header.Set("X-Trace-Id", tt.incoming)
+			}
 
 			handler.ServeHTTP(recorder, req)
 		})
 	}
 }
 
+func TestServeHTTPResponseHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *Config
+		upstream string
+		header   string
+		assert   func(t *testing.T, req string, resp []string)
+	}{
+		{
+			name:   "defaults to request header name",
+			config: &Config{AddToResponse: true},
+			header: "X-Trace-Id",
+			assert: func(t *testing.T, req string, resp []string) {
+				t.Helper()
+				mustHaveValues(t, resp, req)
+			},
+		},
+		{
+			name:   "separate response header name",
+			config: &Config{AddToResponse: true, HeaderName: "X-Internal-Trace", ResponseHeaderName: "Request-Id"},
+			header: "Request-Id",
+			assert: func(t *testing.T, req string, resp []string) {
+				t.Helper()
+				mustHaveValues(t, resp, req)
+			},
+		},
+		{
+			name:     "keep upstream value",
+			config:   &Config{AddToResponse: true, ResponseHeaderPolicy: "keep"},
+			upstream: "from-upstream",
+			header:   "X-Trace-Id",
+			assert: func(t *testing.T, req string, resp []string) {
+				t.Helper()
+				mustHaveValues(t, resp, "from-upstream")
+			},
+		},
+		{
+			name:     "overwrite upstream value",
+			config:   &Config{AddToResponse: true, ResponseHeaderPolicy: "Overwrite"},
+			upstream: "from-upstream",
+			header:   "X-Trace-Id",
+			assert: func(t *testing.T, req string, resp []string) {
+				t.Helper()
+				mustHaveValues(t, resp, req)
+			},
+		},
+		{
+			name:     "emit both values",
+			config:   &Config{AddToResponse: true, ResponseHeaderPolicy: "both"},
+			upstream: "from-upstream",
+			header:   "X-Trace-Id",
+			assert: func(t *testing.T, req string, resp []string) {
+				t.Helper()
+				mustHaveValues(t, resp, "from-upstream", req)
+			},
+		},
+		{
+			name:   "not added to response",
+			config: &Config{AddToResponse: false},
+			header: "X-Trace-Id",
+			assert: func(t *testing.T, req string, resp []string) {
+				t.Helper()
+				mustHaveValues(t, resp)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var reqValue string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				reqValue = req.Header.Get(tt.config.HeaderName)
+				if reqValue == "" {
+					reqValue = req.Header.Get(defaultHeaderName)
+				}
+				if tt.upstream != "" {
+					rw.Header().Set(tt.header, tt.upstream)
+				}
+				_, _ = rw.Write([]byte("ok"))
+			})
+			handler, err := New(ctx, next, tt.config, "trace-id-test")
+			if err != nil {
+				t.Fatalf("error creating new plugin instance: %+v", err)
+			}
+			recorder := httptest.NewRecorder()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+			if err != nil {
+				t.Fatalf("error with request: %+v", err)
+			}
+
+			handler.ServeHTTP(recorder, req)
+			tt.assert(t, reqValue, recorder.Result().Header.Values(tt.header))
+		})
+	}
+}
+
+func TestNewRejectsUnknownResponseHeaderPolicy(t *testing.T) {
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{ResponseHeaderPolicy: "merge"}, "trace-id-test")
+	if err == nil {
+		t.Fatal("expected an error for an unknown response header policy")
+	}
+}
+
+func TestServeHTTPCorrelateUpstream(t *testing.T) {
+	ctx := context.Background()
+
+	var reqValue string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		reqValue = req.Header.Get("X-Trace-Id")
+		rw.Header().Set("X-Trace-Id", "from-upstream")
+		rw.WriteHeader(http.StatusAccepted)
+	})
+	config := &Config{
+		AddToResponse:        true,
+		ResponseHeaderPolicy: "overwrite",
+		CorrelateUpstream:    true,
+		ExposeUpstreamHeader: "X-Upstream-Trace-Id",
+	}
+	handler, err := New(ctx, next, config, "trace-id-test")
+	if err != nil {
+		t.Fatalf("error creating new plugin instance: %+v", err)
+	}
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+	if err != nil {
+		t.Fatalf("error with request: %+v", err)
+	}
+
+	handler.ServeHTTP(recorder, req)
+	resp := recorder.Result()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected upstream status to pass through, got %d", resp.StatusCode)
+	}
+	mustHaveValues(t, resp.Header.Values("X-Trace-Id"), reqValue)
+	mustHaveValues(t, resp.Header.Values("X-Upstream-Trace-Id"), "from-upstream")
+}
+
 func getTraceIdHeader(t *testing.T, req *http.Request, headerName string) string {
 	t.Helper()
 	headerArr := req.Header[headerName]
@@ -160,3 +347,15 @@ func mustHavePrefix(t *testing.T, s, pref string) {
 		t.Fatalf("did not find prefix '%s' in '%s'(%d)", pref, s, len(s))
 	}
 }
+
+func mustHaveValues(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("differing header values: wanted %q, got %q", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("differing header values: wanted %q, got %q", want, got)
+		}
+	}
+}
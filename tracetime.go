@@ -0,0 +1,51 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultTraceTimeHeaderName = "X-Trace-Time"
+
+	// an embedded time outside of this window around now can't be right,
+	// it's a broken clock or an ID that is reused over and over
+	implausibleTraceTimeAge  = 24 * time.Hour
+	implausibleTraceTimeSkew = 5 * time.Minute
+
+	traceTimePast   = "past"
+	traceTimeFuture = "future"
+)
+
+// traceTimePlausibility is "" for a time close enough to now, or tells in
+// which direction it is too far off.
+func traceTimePlausibility(ts, now time.Time, maxAge, maxSkew time.Duration) string {
+	switch {
+	case now.Sub(ts) > maxAge:
+		return traceTimePast
+	case ts.Sub(now) > maxSkew:
+		return traceTimeFuture
+	}
+	return ""
+}
+
+// setTraceTime forwards the time embedded in UUIDv7, ULID and KSUID trace IDs, so
+// upstream can work out how long the request was queued. Implausible times
+// are still forwarded, but flagged in traceTimeHeaderName-Implausible.
+func (t *TraceIDHeader) setTraceTime(req *http.Request, traceID TraceID, now time.Time) {
+	// never pass on what a client claims
+	req.Header.Del(t.traceTimeHeaderName)
+	req.Header.Del(t.traceTimeHeaderName + "-Implausible")
+
+	ts, ok := traceID.Time()
+	if !ok {
+		return
+	}
+	req.Header.Set(t.traceTimeHeaderName, ts.Format(time.RFC3339Nano))
+	if flag := traceTimePlausibility(ts, now, implausibleTraceTimeAge, implausibleTraceTimeSkew); flag != "" {
+		req.Header.Set(t.traceTimeHeaderName+"-Implausible", flag)
+		t.logger.Warn("trace id time is implausible", append(requestLogAttrs(req, traceID.Value),
+			slog.String("source", traceID.Source), slog.String("traceTime", ts.Format(time.RFC3339Nano)), slog.String("off", flag))...)
+	}
+}
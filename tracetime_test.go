@@ -0,0 +1,89 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTraceTimeHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *Config
+		incoming string
+		wantTime string // "" = no header, "now" = close to now
+		wantFlag string
+	}{
+		{
+			name:     "generated uuidv7",
+			config:   &Config{UuidGen: "7"},
+			wantTime: "now",
+		},
+		{
+			name:     "generated ulid",
+			config:   &Config{UuidGen: "L"},
+			wantTime: "now",
+		},
+		{
+			name:     "uuidv4 has no time",
+			config:   &Config{UuidGen: "4"},
+			incoming: "",
+		},
+		{
+			name:     "propagated old ulid",
+			config:   &Config{UuidGen: "L", TrustAllIPs: true},
+			incoming: "01ARZ3NDEKTSV4RRFFQ69G5FAV",
+			wantTime: "2016-07-30T23:54:10.259Z",
+			wantFlag: traceTimePast,
+		},
+		{
+			name:     "propagated uuidv7 from the future",
+			config:   &Config{UuidGen: "7", TrustAllIPs: true},
+			incoming: "7fffffff-ffff-7fff-bfff-ffffffffffff",
+			wantTime: "6429-10-17T02:45:55.327Z",
+			wantFlag: traceTimeFuture,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			tt.config.TraceTime = true
+
+			var got, flag string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got = req.Header.Get("X-Trace-Time")
+				flag = req.Header.Get("X-Trace-Time-Implausible")
+			})
+			handler, err := New(ctx, next, tt.config, "trace-id-test")
+			if err != nil {
+				t.Fatalf("error creating new plugin instance: %+v", err)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+			if err != nil {
+				t.Fatalf("error with request: %+v", err)
+			}
+			req.Header.Set("X-Trace-Time", "2000-01-01T00:00:00Z") // spoofed, must not reach upstream
+			if tt.incoming != "" {
+				req.Header.Set("X-Trace-Id", tt.incoming)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			switch tt.wantTime {
+			case "now":
+				ts, err := time.Parse(time.RFC3339Nano, got)
+				if err != nil || time.Since(ts).Abs() > time.Minute {
+					t.Fatalf("expected a time close to now, got %q", got)
+				}
+			default:
+				if got != tt.wantTime {
+					t.Fatalf("expected trace time %q, got %q", tt.wantTime, got)
+				}
+			}
+			if flag != tt.wantFlag {
+				t.Fatalf("expected implausible flag %q, got %q", tt.wantFlag, flag)
+			}
+		})
+	}
+}
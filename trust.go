@@ -0,0 +1,81 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseTrustNetworks turns the configured CIDRs (or bare IPs) into networks.
+func parseTrustNetworks(networks []string) ([]*net.IPNet, error) {
+	var parsed []*net.IPNet
+	for _, n := range networks {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		if !strings.Contains(n, "/") {
+			ip := net.ParseIP(n)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted IP %q", n)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			parsed = append(parsed, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(n)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted network %q: %w", n, err)
+		}
+		parsed = append(parsed, ipNet)
+	}
+	return parsed, nil
+}
+
+// isTrusted reports whether the directly connected client may hand us its own trace ID.
+func (t *TraceIDHeader) isTrusted(req *http.Request) bool {
+	if t.trustAllIPs {
+		return true
+	}
+	return remoteAddrInNetworks(req.RemoteAddr, t.trustNetworks)
+}
+
+func remoteAddrInNetworks(remoteAddr string, networks []*net.IPNet) bool {
+	if len(networks) == 0 {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr // no port
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidIncomingTraceId guards against clients stuffing junk into our header:
+// it must be reasonably short and consist of visible ASCII only.
+func isValidIncomingTraceId(value string) bool {
+	if value == "" || len(value) > maxIncomingTraceIdLength {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] <= ' ' || value[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestIsTrusted(t *testing.T) {
+	tests := []struct {
+		name        string
+		trustAllIPs bool
+		networks    []string
+		remoteAddr  string
+		want        bool
+	}{
+		{name: "nothing trusted by default", remoteAddr: "10.1.2.3:1234", want: false},
+		{name: "trust all", trustAllIPs: true, remoteAddr: "192.0.2.1:1234", want: true},
+		{name: "inside CIDR", networks: []string{"10.0.0.0/8"}, remoteAddr: "10.1.2.3:1234", want: true},
+		{name: "outside CIDR", networks: []string{"10.0.0.0/8"}, remoteAddr: "11.1.2.3:1234", want: false},
+		{name: "bare IPv4 matches itself", networks: []string{"192.0.2.1"}, remoteAddr: "192.0.2.1:1234", want: true},
+		{name: "bare IPv4 matches only itself", networks: []string{"192.0.2.1"}, remoteAddr: "192.0.2.2:1234", want: false},
+		{name: "bare IPv6", networks: []string{"2001:db8::1"}, remoteAddr: "[2001:db8::1]:1234", want: true},
+		{name: "IPv6 CIDR", networks: []string{"2001:db8::/32"}, remoteAddr: "[2001:db8:1::5]:1234", want: true},
+		{name: "remote address without port", networks: []string{"10.0.0.0/8"}, remoteAddr: "10.1.2.3", want: true},
+		{name: "IPv6 remote address without port", networks: []string{"::1"}, remoteAddr: "::1", want: true},
+		{name: "unparseable remote address", networks: []string{"10.0.0.0/8"}, remoteAddr: "somewhere", want: false},
+		{name: "whitespace and empty entries are ignored", networks: []string{" 10.0.0.0/8 ", ""}, remoteAddr: "10.1.2.3:1234", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			networks, err := parseTrustNetworks(tt.networks)
+			if err != nil {
+				t.Fatalf("error parsing trusted networks: %v", err)
+			}
+			testMe := &TraceIDHeader{trustAllIPs: tt.trustAllIPs, trustNetworks: networks}
+			req := &http.Request{RemoteAddr: tt.remoteAddr}
+			if got := testMe.isTrusted(req); got != tt.want {
+				t.Fatalf("isTrusted(%q) with %q: wanted %v, got %v", tt.remoteAddr, tt.networks, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIsValidIncomingTraceId(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{value: "f47ac10b-58cc-4372-a567-0e02b2c3d479", want: true},
+		{value: "myorg-01ARZ3NDEKTSV4RRFFQ69G5FAV", want: true},
+		{value: "", want: false},
+		{value: "with space", want: false},
+		{value: "bad\x01value", want: false},
+		{value: "café", want: false},
+		{value: strings.Repeat("a", maxIncomingTraceIdLength), want: true},
+		{value: strings.Repeat("a", maxIncomingTraceIdLength+1), want: false},
+	}
+	for _, tt := range tests {
+		if got := isValidIncomingTraceId(tt.value); got != tt.want {
+			t.Fatalf("isValidIncomingTraceId(%q): wanted %v, got %v", tt.value, tt.want, got)
+		}
+	}
+}
+
+func TestNewRejectsInvalidTrustNetworks(t *testing.T) {
+	for _, network := range []string{"10.0.0.0/33", "not-an-ip"} {
+		_, err := New(context.Background(), http.NotFoundHandler(), &Config{TrustNetworks: []string{network}}, "trace-id-test")
+		if err == nil {
+			t.Fatalf("expected an error for trusted network %q", network)
+		}
+	}
+}
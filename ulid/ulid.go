@@ -147,6 +147,64 @@ func Make() (id ULID) { //2
 	return MustNew(Now(), DefaultEntropy())
 }
 
+// ParseStrict parses an encoded ULID, returning an error in case of failure.
+// It validates that the parsed ULID consists only of valid base32 characters.
+//
+// ErrDataSize is returned if the len(ulid) is different from an encoded
+// ULID's length. Invalid encodings return ErrInvalidCharacters.
+func ParseStrict(ulid string) (id ULID, err error) { //2
+	return id, parse([]byte(ulid), &id)
+}
+
+func parse(v []byte, id *ULID) error {
+	// Check if a base32 encoded ULID is the right length.
+	if len(v) != EncodedSize {
+		return ErrDataSize
+	}
+
+	// Check if all the characters in a base32 encoded ULID are part of the
+	// expected base32 character set.
+	for _, c := range v {
+		if dec[c] == 0xFF {
+			return ErrInvalidCharacters
+		}
+	}
+
+	// Check if the first character in a base32 encoded ULID will overflow. This
+	// happens because the base32 representation encodes 130 bits, while the
+	// ULID is only 128 bits.
+	//
+	// See https://github.com/oklog/ulid/issues/9 for details.
+	if v[0] > '7' {
+		return ErrOverflow
+	}
+
+	// Use an optimized unrolled loop (from https://github.com/RobThree/NUlid)
+	// to decode a base32 ULID.
+
+	// 6 bytes timestamp (48 bits)
+	(*id)[0] = (dec[v[0]] << 5) | dec[v[1]]
+	(*id)[1] = (dec[v[2]] << 3) | (dec[v[3]] >> 2)
+	(*id)[2] = (dec[v[3]] << 6) | (dec[v[4]] << 1) | (dec[v[5]] >> 4)
+	(*id)[3] = (dec[v[5]] << 4) | (dec[v[6]] >> 1)
+	(*id)[4] = (dec[v[6]] << 7) | (dec[v[7]] << 2) | (dec[v[8]] >> 3)
+	(*id)[5] = (dec[v[8]] << 5) | dec[v[9]]
+
+	// 10 bytes of entropy (80 bits)
+	(*id)[6] = (dec[v[10]] << 3) | (dec[v[11]] >> 2)
+	(*id)[7] = (dec[v[11]] << 6) | (dec[v[12]] << 1) | (dec[v[13]] >> 4)
+	(*id)[8] = (dec[v[13]] << 4) | (dec[v[14]] >> 1)
+	(*id)[9] = (dec[v[14]] << 7) | (dec[v[15]] << 2) | (dec[v[16]] >> 3)
+	(*id)[10] = (dec[v[16]] << 5) | dec[v[17]]
+	(*id)[11] = (dec[v[18]] << 3) | dec[v[19]]>>2
+	(*id)[12] = (dec[v[19]] << 6) | (dec[v[20]] << 1) | (dec[v[21]] >> 4)
+	(*id)[13] = (dec[v[21]] << 4) | (dec[v[22]] >> 1)
+	(*id)[14] = (dec[v[22]] << 7) | (dec[v[23]] << 2) | (dec[v[24]] >> 3)
+	(*id)[15] = (dec[v[24]] << 5) | dec[v[25]]
+
+	return nil
+}
+
 // Bytes returns bytes slice representation of ULID.
 func (id ULID) Bytes() []byte {
 	return id[:]
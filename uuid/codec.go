@@ -0,0 +1,92 @@
+// Copyright (C) 2013-2018 by Maxim Bublis <b@codemonkey.ru>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package uuid provides implementations of the Universally Unique Identifier
+package uuid
+
+import (
+	"encoding/hex"
+)
+
+const (
+	ErrIncorrectLength         = Error("uuid: incorrect UUID length")
+	ErrIncorrectFormatInString = Error("uuid: incorrect UUID format in string")
+)
+
+// FromString returns a UUID parsed from the input string.
+// Input is expected in a form accepted by UnmarshalText.
+func FromString(text string) (UUID, error) {
+	u := UUID{}
+	err := u.UnmarshalText([]byte(text))
+	return u, err
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+// Following formats are supported:
+//
+//	"6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+//	"{6ba7b810-9dad-11d1-80b4-00c04fd430c8}",
+//	"urn:uuid:6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+//	"6ba7b8109dad11d180b400c04fd430c8"
+func (u *UUID) UnmarshalText(b []byte) error {
+	switch len(b) {
+	case 32: // hash
+		return u.decodeHashLike(b)
+	case 36: // canonical
+		return u.decodeCanonical(b)
+	case 38: // braced
+		if b[0] != '{' || b[37] != '}' {
+			return ErrIncorrectFormatInString
+		}
+		return u.decodeCanonical(b[1:37])
+	case 45: // urn
+		if string(b[:9]) != "urn:uuid:" {
+			return ErrIncorrectFormatInString
+		}
+		return u.decodeCanonical(b[9:])
+	default:
+		return ErrIncorrectLength
+	}
+}
+
+// decodeCanonical decodes UUID strings that are formatted as defined in RFC-9562 (section 4):
+// "6ba7b810-9dad-11d1-80b4-00c04fd430c8".
+func (u *UUID) decodeCanonical(b []byte) error {
+	if b[8] != '-' || b[13] != '-' || b[18] != '-' || b[23] != '-' {
+		return ErrIncorrectFormatInString
+	}
+	hash := make([]byte, 0, 32)
+	hash = append(hash, b[0:8]...)
+	hash = append(hash, b[9:13]...)
+	hash = append(hash, b[14:18]...)
+	hash = append(hash, b[19:23]...)
+	hash = append(hash, b[24:36]...)
+	return u.decodeHashLike(hash)
+}
+
+// decodeHashLike decodes UUID strings that are using the following format:
+// "6ba7b8109dad11d180b400c04fd430c8".
+func (u *UUID) decodeHashLike(b []byte) error {
+	if _, err := hex.Decode(u[:], b); err != nil {
+		return ErrIncorrectFormatInString
+	}
+	return nil
+}
@@ -81,6 +81,22 @@ func (u UUID) Version() byte {
 	return u[6] >> 4
 }
 
+// Variant returns the UUID layout variant.
+func (u UUID) Variant() byte {
+	switch {
+	case (u[8] >> 7) == 0x00:
+		return VariantNCS
+	case (u[8] >> 6) == 0x02:
+		return VariantRFC9562
+	case (u[8] >> 5) == 0x06:
+		return VariantMicrosoft
+	case (u[8] >> 5) == 0x07:
+		fallthrough
+	default:
+		return VariantFuture
+	}
+}
+
 // Bytes returns a byte slice representation of the UUID.
 func (u UUID) Bytes() []byte {
 	return u[:]
@@ -0,0 +1,92 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// zipkinEndpoint is the localEndpoint / remoteEndpoint of a Zipkin v2 span.
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName,omitempty"`
+	IPv4        string `json:"ipv4,omitempty"`
+	IPv6        string `json:"ipv6,omitempty"`
+	Port        int    `json:"port,omitempty"`
+}
+
+type zipkinSpan struct {
+	TraceID        string            `json:"traceId"`
+	ID             string            `json:"id"`
+	ParentID       string            `json:"parentId,omitempty"`
+	Kind           string            `json:"kind"`
+	Name           string            `json:"name"`
+	Timestamp      int64             `json:"timestamp"`
+	Duration       int64             `json:"duration"`
+	LocalEndpoint  zipkinEndpoint    `json:"localEndpoint"`
+	RemoteEndpoint *zipkinEndpoint   `json:"remoteEndpoint,omitempty"`
+	Tags           map[string]string `json:"tags"`
+}
+
+// remoteZipkinEndpoint turns the client's address into a Zipkin endpoint, if it is an IP.
+func remoteZipkinEndpoint(remoteAddr string) *zipkinEndpoint {
+	host, port, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	endpoint := &zipkinEndpoint{}
+	if ip.To4() != nil {
+		endpoint.IPv4 = ip.String()
+	} else {
+		endpoint.IPv6 = ip.String()
+	}
+	endpoint.Port, _ = strconv.Atoi(port)
+	return endpoint
+}
+
+func encodeZipkin(serviceName string, batch []spanRecord) ([]byte, error) {
+	spans := make([]zipkinSpan, 0, len(batch))
+	for _, s := range batch {
+		if s.TraceHex == "" {
+			continue
+		}
+		tags := map[string]string{
+			"http.method":      s.Method,
+			"http.path":        s.Path,
+			"http.host":        s.Host,
+			"http.status_code": strconv.Itoa(s.Status),
+			"trace_id.value":   s.TraceValue,
+			"trace_id.source":  s.Source,
+		}
+		if s.Status >= 500 {
+			tags["error"] = strconv.Itoa(s.Status)
+		}
+		spans = append(spans, zipkinSpan{
+			TraceID:        s.TraceHex,
+			ID:             s.SpanID,
+			ParentID:       s.ParentSpanID,
+			Kind:           "SERVER",
+			Name:           strings.ToLower(s.Name),
+			Timestamp:      s.Start.UnixMicro(),
+			Duration:       max(s.End.Sub(s.Start).Microseconds(), 1),
+			LocalEndpoint:  zipkinEndpoint{ServiceName: serviceName},
+			RemoteEndpoint: remoteZipkinEndpoint(s.RemoteAddr),
+			Tags:           tags,
+		})
+	}
+	return json.Marshal(spans)
+}
+
+// sendZipkin POSTs one batch to the Zipkin /api/v2/spans endpoint.
+func (t *TraceIDHeader) sendZipkin(ctx context.Context, batch []spanRecord) error {
+	body, err := encodeZipkin(t.serviceName, batch)
+	if err != nil {
+		return err
+	}
+	return t.postSpans(ctx, t.zipkinEndpoint, "application/json", body, nil)
+}
@@ -0,0 +1,87 @@
+package traefik_add_trace_id_header_2
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestZipkinExport(t *testing.T) {
+	collector, received := newTestCollector(t)
+	config := &Config{
+		ZipkinEndpoint:  collector.URL + "/api/v2/spans",
+		ExportBatchSize: 1,
+		SpanIds:         true,
+		TrustAllIPs:     true,
+	}
+	traceID := serveExportedRequest(t, config, http.StatusBadGateway)
+
+	export := waitForExport(t, received)
+	if export.contentType != "application/json" {
+		t.Fatalf("unexpected content type %q", export.contentType)
+	}
+	var spans []zipkinSpan
+	if err := json.Unmarshal(export.body, &spans); err != nil {
+		t.Fatalf("collector got invalid JSON: %v", err)
+	}
+	if len(spans) != 1 {
+		t.Fatalf("expected one span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.TraceID != traceID.Hex() || span.ID != traceID.SpanID || span.ParentID != "00f067aa0ba902b7" {
+		t.Fatalf("unexpected ids %+v for %+v", span, traceID)
+	}
+	if span.Kind != "SERVER" || span.LocalEndpoint.ServiceName != "trace-id-test" {
+		t.Fatalf("unexpected kind or service name: %+v", span)
+	}
+	if span.Timestamp <= 0 || span.Duration <= 0 {
+		t.Fatalf("expected timestamp and duration in microseconds: %+v", span)
+	}
+	if span.Tags["http.status_code"] != "502" || span.Tags["error"] != "502" || span.Tags["http.method"] != "GET" {
+		t.Fatalf("unexpected tags %v", span.Tags)
+	}
+}
+
+func TestSpanBatcherRetries(t *testing.T) {
+	var attempts int32
+	collector := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.Copy(io.Discard, req.Body)
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(collector.Close)
+
+	tIDHdr := &TraceIDHeader{zipkinEndpoint: collector.URL, exportClient: collector.Client()}
+	b := &spanBatcher{kind: "zipkin", send: tIDHdr.sendZipkin, maxRetries: 3, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	start := time.Now()
+	b.sendWithRetry(context.Background(), []spanRecord{{TraceHex: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Start: start, End: start}})
+
+	if atomic.LoadInt32(&attempts) != 3 || b.exported != 1 || b.failed != 0 {
+		t.Fatalf("expected success on the third attempt, got %d attempts, %d exported, %d failed", attempts, b.exported, b.failed)
+	}
+}
+
+func TestSpanBatcherDoesNotRetryClientErrors(t *testing.T) {
+	var attempts int32
+	collector := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		rw.WriteHeader(http.StatusBadRequest)
+	}))
+	t.Cleanup(collector.Close)
+
+	tIDHdr := &TraceIDHeader{zipkinEndpoint: collector.URL, exportClient: collector.Client()}
+	b := &spanBatcher{kind: "zipkin", send: tIDHdr.sendZipkin, maxRetries: 3, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	start := time.Now()
+	b.sendWithRetry(context.Background(), []spanRecord{{TraceHex: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Start: start, End: start}})
+
+	if atomic.LoadInt32(&attempts) != 1 || b.failed != 1 {
+		t.Fatalf("expected a single failed attempt, got %d attempts, %d failed", attempts, b.failed)
+	}
+}